@@ -0,0 +1,145 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// AccountStore keeps the ACME account, including its private key, between runs
+type AccountStore interface {
+	// Load returns the saved account, or nil if there isn't one
+	Load(ctx context.Context) (*helpers.User, error)
+	Save(ctx context.Context, user *helpers.User) error
+}
+
+// newAccountStore returns the AccountStore for ACCOUNT_STORE, which is
+// ssm:<parameter name> or s3://<bucket>/<key>. It returns nil if the setting is
+// empty, and a new account is registered each run.
+func newAccountStore(location string, sess *session.Session) (AccountStore, error) {
+	switch {
+	case location == "":
+		return nil, nil
+	case strings.HasPrefix(location, "ssm:"):
+		return &ssmAccountStore{client: ssm.New(sess), name: strings.TrimPrefix(location, "ssm:")}, nil
+	case strings.HasPrefix(location, "s3://"):
+		bucket, key := splitS3URL(location)
+		if bucket == "" || key == "" {
+			return nil, fmt.Errorf("invalid ACCOUNT_STORE %q: expected s3://bucket/key", location)
+		}
+		return &s3AccountStore{client: s3.New(sess), bucket: bucket, key: key}, nil
+	default:
+		return nil, fmt.Errorf("invalid ACCOUNT_STORE %q: expected ssm:<name> or s3://bucket/key", location)
+	}
+}
+
+// splitS3URL splits s3://bucket/key into its bucket and key
+func splitS3URL(location string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(location, "s3://"), "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// ssmAccountStore keeps the account in an SSM SecureString parameter
+type ssmAccountStore struct {
+	client ssmiface.SSMAPI
+	name   string
+}
+
+func (s *ssmAccountStore) Load(ctx context.Context) (*helpers.User, error) {
+	resp, err := s.client.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(s.name),
+		WithDecryption: aws.Bool(true),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user := &helpers.User{}
+	err = json.Unmarshal([]byte(aws.StringValue(resp.Parameter.Value)), user)
+
+	return user, err
+}
+
+func (s *ssmAccountStore) Save(ctx context.Context, user *helpers.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.PutParameterWithContext(ctx, &ssm.PutParameterInput{
+		Name:      aws.String(s.name),
+		Value:     aws.String(string(data)),
+		Type:      aws.String(ssm.ParameterTypeSecureString),
+		Overwrite: aws.Bool(true),
+	})
+
+	return err
+}
+
+// s3AccountStore keeps the account in an encrypted S3 object
+type s3AccountStore struct {
+	client s3iface.S3API
+	bucket string
+	key    string
+}
+
+func (s *s3AccountStore) Load(ctx context.Context) (*helpers.User, error) {
+	resp, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &helpers.User{}
+	err = json.Unmarshal(data, user)
+
+	return user, err
+}
+
+func (s *s3AccountStore) Save(ctx context.Context, user *helpers.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(s.bucket),
+		Key:                  aws.String(s.key),
+		Body:                 bytes.NewReader(data),
+		ContentType:          aws.String("application/json"),
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	})
+
+	return err
+}
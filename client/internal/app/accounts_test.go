@@ -0,0 +1,82 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// fakeS3 keeps objects in memory, keyed by bucket/key
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}}
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	b, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)] = b
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	b, ok := f.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+	}
+
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func TestS3AccountStore(t *testing.T) {
+	f := newFakeS3()
+	s := &s3AccountStore{client: f, bucket: "bucket", key: "account.json"}
+
+	user, err := s.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != nil {
+		t.Errorf("Expected no account, got %v", user)
+	}
+
+	user, err = helpers.NewUser("dev@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	// The private key is saved with the account
+	var saved map[string]interface{}
+	if err := json.Unmarshal(f.objects["bucket/account.json"], &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved["key"] == "" {
+		t.Errorf("Expected the private key to be saved")
+	}
+
+	loaded, err := s.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectStringMatch(t, "dev@example.com", loaded.GetEmail())
+}
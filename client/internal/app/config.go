@@ -0,0 +1,196 @@
+// package app is the certificate creation lambda. The solver, challenge store,
+// account store and certificate destinations are chosen by environment
+// variables, so that one handler can replace the per-solver clients.
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	solver "github.com/sjauld/acme-sls/solver/http-s3"
+)
+
+// Providers that can solve a challenge, named as in SOLVER and in the
+// challenge rules of a request
+const (
+	ProviderS3      = "s3"      // HTTP-01, written to S3 by solver/http-s3
+	ProviderStore   = "store"   // HTTP-01, written to a challenge Store for the servers
+	ProviderALB     = "alb"     // HTTP-01, answered by an ALB listener rule
+	ProviderACM     = "acm"     // TLS-ALPN-01, imported into ACM
+	ProviderRoute53 = "route53" // DNS-01, written to Route 53
+)
+
+// Store backends for the store provider
+const (
+	storeDynamoDB = "dynamodb"
+	storeS3       = "s3"
+	storeRedis    = "redis"
+)
+
+const (
+	fallbackDynamoDBTable    = "acme-sls-certificates"
+	fallbackEmail            = "dev@null.com"
+	fallbackRenewalWindow    = "168h"
+	fallbackS3Region         = "us-east-1"
+	fallbackSelfCheckTimeout = "2m"
+)
+
+// Config holds the settings of the lambda
+type Config struct {
+	UserEmail        string
+	RenewalWindow    time.Duration
+	SelfCheckTimeout time.Duration
+
+	// Solver is the provider for domains that the request has no rule for
+	Solver string
+
+	// AccountStore keeps the ACME account between runs, e.g.
+	// ssm:/acme-sls/account or s3://bucket/account.json. If it's empty a new
+	// account is registered each time.
+	AccountStore string
+	// Destinations are where certificates are imported, e.g. acm,
+	// acm:us-east-1 or s3://bucket/prefix
+	Destinations []string
+
+	// The s3 provider
+	S3Region           string
+	S3Delay            time.Duration
+	S3Mapper           solver.Mapper
+	S3ACL              string
+	S3MirrorBuckets    string
+	S3ReplicaBuckets   string
+	ReplicationTimeout time.Duration
+
+	// The store provider
+	StoreBackend  string
+	DynamoDBTable string
+	S3Bucket      string
+	S3KeyPrefix   string
+	RedisAddr     string
+
+	// The alb provider
+	ALBListenerARN string
+}
+
+// ConfigFromEnv reads the Config from the environment. defaultSolver is used
+// if SOLVER isn't set, so that the old clients keep their behaviour.
+func ConfigFromEnv(defaultSolver string) (*Config, error) {
+	c := &Config{
+		UserEmail:      envString("USER_EMAIL", fallbackEmail),
+		Solver:         envString("SOLVER", defaultSolver),
+		AccountStore:   os.Getenv("ACCOUNT_STORE"),
+		Destinations:   splitList(envString("CERTIFICATE_DESTINATIONS", "acm")),
+		S3Region:       envString("S3_REGION", fallbackS3Region),
+		StoreBackend:   envString("STORE_BACKEND", storeDynamoDB),
+		S3Bucket:       os.Getenv("S3_BUCKET_NAME"),
+		S3KeyPrefix:    os.Getenv("S3_KEY_PREFIX"),
+		RedisAddr:      os.Getenv("REDIS_ADDR"),
+		ALBListenerARN: os.Getenv("ALB_LISTENER_ARN"),
+	}
+
+	// lambda-http called it DYNAMODB_TABLE, and the servers DYNAMODB_TABLE_NAME
+	c.DynamoDBTable = envString("DYNAMODB_TABLE_NAME", envString("DYNAMODB_TABLE", fallbackDynamoDBTable))
+
+	c.RenewalWindow = envDuration("RENEWAL_WINDOW", fallbackRenewalWindow)
+	// Check that the challenge is reachable before notifying the CA; 0 disables
+	// the check
+	c.SelfCheckTimeout = envDuration("SELF_CHECK_TIMEOUT", fallbackSelfCheckTimeout)
+	c.S3Delay = envDuration("S3_DELAY", "0")
+	c.ReplicationTimeout = envDuration("S3_REPLICATION_TIMEOUT", solver.DefaultReplicationTimeout.String())
+
+	// By default each domain has its own bucket. S3_BUCKET_NAME switches to a
+	// single shared bucket, and S3_BUCKET_MAP lists domain=bucket[/prefix]
+	// exceptions to either.
+	c.S3Mapper = solver.DomainBucket
+	if c.S3Bucket != "" {
+		c.S3Mapper = solver.SharedBucket(c.S3Bucket, c.S3KeyPrefix)
+	}
+	if s := os.Getenv("S3_BUCKET_MAP"); s != "" {
+		targets, err := solver.ParseBucketMap(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3_BUCKET_MAP: %w", err)
+		}
+		c.S3Mapper = solver.StaticMapper(targets, c.S3Mapper)
+	}
+
+	// Objects are public by default so that the S3 website endpoint can serve
+	// them; "none" relies on the bucket policy or CloudFront OAC instead
+	c.S3ACL = s3.ObjectCannedACLPublicRead
+	if s, ok := os.LookupEnv("S3_ACL"); ok {
+		c.S3ACL = s
+		if s == "none" {
+			c.S3ACL = ""
+		}
+	}
+
+	// Mirrors and replicas are listed as bucket[@region], defaulting to S3_REGION
+	c.S3MirrorBuckets = os.Getenv("S3_MIRROR_BUCKETS")
+	c.S3ReplicaBuckets = os.Getenv("S3_REPLICA_BUCKETS")
+
+	return c, c.validate()
+}
+
+// validate checks the settings that can't fall back to a default
+func (c *Config) validate() error {
+	if _, ok := providerTypes[c.Solver]; !ok {
+		return fmt.Errorf("unknown SOLVER %q", c.Solver)
+	}
+
+	switch c.StoreBackend {
+	case storeDynamoDB, storeRedis:
+	case storeS3:
+		if c.Solver == ProviderStore && c.S3Bucket == "" {
+			return fmt.Errorf("the %v store needs S3_BUCKET_NAME", storeS3)
+		}
+	default:
+		return fmt.Errorf("unknown STORE_BACKEND %q", c.StoreBackend)
+	}
+
+	if c.Solver == ProviderALB && c.ALBListenerARN == "" {
+		return fmt.Errorf("the %v solver needs ALB_LISTENER_ARN", ProviderALB)
+	}
+
+	if len(c.Destinations) == 0 {
+		return fmt.Errorf("CERTIFICATE_DESTINATIONS is empty")
+	}
+
+	return nil
+}
+
+// envString returns the environment variable, or def if it isn't set
+func envString(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+
+	return def
+}
+
+// envDuration parses the environment variable as a duration, falling back to
+// def if it isn't set or isn't valid
+func envDuration(key, def string) time.Duration {
+	s := os.Getenv(key)
+	// Make sure the env variable is a valid duration
+	if _, err := time.ParseDuration(s); err != nil {
+		s = def
+	}
+	d, _ := time.ParseDuration(s)
+
+	return d
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+
+	return list
+}
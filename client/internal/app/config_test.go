@@ -0,0 +1,128 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+func TestConfigFromEnv_defaults(t *testing.T) {
+	// The old lambdas defaulted to "7d", which isn't a valid duration
+	t.Setenv("RENEWAL_WINDOW", "7d")
+	t.Setenv("DYNAMODB_TABLE", "legacy")
+
+	c, err := ConfigFromEnv(ProviderStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, ProviderStore, c.Solver)
+	helpers.ExpectStringMatch(t, "legacy", c.DynamoDBTable)
+	helpers.ExpectIntMatch(t, 1, len(c.Destinations))
+	helpers.ExpectStringMatch(t, "acm", c.Destinations[0])
+	if c.RenewalWindow != 168*time.Hour {
+		t.Errorf("Expected %v, got %v", 168*time.Hour, c.RenewalWindow)
+	}
+	if c.SelfCheckTimeout != 2*time.Minute {
+		t.Errorf("Expected %v, got %v", 2*time.Minute, c.SelfCheckTimeout)
+	}
+}
+
+func TestConfigFromEnv_wrappers(t *testing.T) {
+	// The default SOLVER of each binary, as deployed without SOLVER set
+	tests := map[string]string{
+		"lambda":         "s3",
+		"lambda-http-s3": "s3",
+		"lambda-http":    "store",
+		"lambda-tls":     "acm",
+	}
+
+	for name, solver := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, err := ConfigFromEnv(solver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			helpers.ExpectStringMatch(t, solver, c.Solver)
+		})
+	}
+
+	helpers.ExpectStringMatch(t, "s3", ProviderS3)
+	helpers.ExpectStringMatch(t, "store", ProviderStore)
+	helpers.ExpectStringMatch(t, "acm", ProviderACM)
+}
+
+func TestConfigFromEnv_overrides(t *testing.T) {
+	t.Setenv("SOLVER", ProviderALB)
+	t.Setenv("ALB_LISTENER_ARN", "arn:listener")
+	t.Setenv("CERTIFICATE_DESTINATIONS", "acm, acm:us-east-1,s3://bucket/certs")
+	t.Setenv("S3_ACL", "none")
+
+	c, err := ConfigFromEnv(ProviderS3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, ProviderALB, c.Solver)
+	helpers.ExpectIntMatch(t, 3, len(c.Destinations))
+	helpers.ExpectStringMatch(t, "acm:us-east-1", c.Destinations[1])
+	helpers.ExpectStringMatch(t, "", c.S3ACL)
+}
+
+func TestConfigFromEnv_invalid(t *testing.T) {
+	tests := map[string]map[string]string{
+		"solver":   {"SOLVER": "ftp"},
+		"store":    {"STORE_BACKEND": "mysql"},
+		"alb":      {"SOLVER": ProviderALB},
+		"s3 store": {"SOLVER": ProviderStore, "STORE_BACKEND": storeS3},
+	}
+
+	for name, env := range tests {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+
+			if _, err := ConfigFromEnv(ProviderS3); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	p := &providers{c: &Config{Solver: ProviderStore}}
+
+	tests := []struct {
+		rule         ChallengeRule
+		expectedName string
+		expectedType challenge.Type
+	}{
+		{ChallengeRule{Domain: "*"}, ProviderStore, challenge.HTTP01},
+		{ChallengeRule{Domain: "*", Type: "http-01"}, ProviderStore, challenge.HTTP01},
+		{ChallengeRule{Domain: "*", Type: "dns-01"}, ProviderRoute53, challenge.DNS01},
+		{ChallengeRule{Domain: "*", Provider: "s3"}, ProviderS3, challenge.HTTP01},
+		{ChallengeRule{Domain: "*", Type: "tls-alpn-01"}, ProviderACM, challenge.TLSALPN01},
+	}
+
+	for _, tt := range tests {
+		name, typ, err := p.resolve(tt.rule)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		helpers.ExpectStringMatch(t, tt.expectedName, name)
+		helpers.ExpectStringMatch(t, tt.expectedType.String(), typ.String())
+	}
+
+	// The provider has to solve the type
+	if _, _, err := p.resolve(ChallengeRule{Domain: "*", Type: "dns-01", Provider: "s3"}); err == nil {
+		t.Errorf("Expected an error for dns-01 via s3")
+	}
+	if _, _, err := p.resolve(ChallengeRule{Domain: "*", Provider: "ftp"}); err == nil {
+		t.Errorf("Expected an error for an unknown provider")
+	}
+}
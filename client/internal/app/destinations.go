@@ -0,0 +1,34 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/sjauld/acme-sls/issuer"
+)
+
+// newDestination returns the Destination for an entry in
+// CERTIFICATE_DESTINATIONS, which is acm, acm:<region> or s3://<bucket>/<prefix>
+func newDestination(location string, sess *session.Session) (issuer.Destination, error) {
+	switch {
+	case location == "acm":
+		return issuer.NewACMDestination(acm.New(sess)), nil
+	case strings.HasPrefix(location, "acm:"):
+		// e.g. us-east-1 for CloudFront
+		region := strings.TrimPrefix(location, "acm:")
+		return issuer.NewACMDestination(acm.New(sess.Copy(&aws.Config{Region: aws.String(region)}))), nil
+	case strings.HasPrefix(location, "s3://"):
+		bucket, prefix := splitS3URL(location)
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid certificate destination %q: expected s3://bucket/prefix", location)
+		}
+		return issuer.NewS3Destination(s3.New(sess), bucket, prefix), nil
+	default:
+		return nil, fmt.Errorf("invalid certificate destination %q: expected acm, acm:<region> or s3://bucket/prefix", location)
+	}
+}
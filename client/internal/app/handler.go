@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
+
+	"github.com/sjauld/acme-sls/helpers"
+	"github.com/sjauld/acme-sls/issuer"
+	"github.com/sjauld/acme-sls/solver/composite"
+)
+
+// finishTime is how much of the invocation is kept back from the challenges to
+// finish the order and import the certificate
+const finishTime = 30 * time.Second
+
+// Response is what the lambda returns
+type Response struct {
+	// Issued is true if a new certificate was obtained
+	Issued bool `json:"issued"`
+	// Validations describe how each domain was validated, if the certificate
+	// was requested
+	Validations []issuer.Validation `json:"validations,omitempty"`
+}
+
+// Handler returns the lambda handler for CloudWatch events whose detail is a
+// CertificateRequest. The AWS session is created straight away, during cold
+// start.
+func Handler(c *Config) func(context.Context, events.CloudWatchEvent) (*Response, error) {
+	sess := session.Must(session.NewSession())
+
+	return func(ctx context.Context, event events.CloudWatchEvent) (*Response, error) {
+		log.Printf("[INFO] Processing certificate request: %v", string(event.Detail))
+		// Unmarshal the request
+		var cr CertificateRequest
+		if err := json.Unmarshal(event.Detail, &cr); err != nil {
+			return nil, err
+		}
+
+		iss, err := newIssuer(c, sess, &cr)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := iss.Renew(ctx, issuer.Request{ID: cr.ID, Domains: cr.Domains})
+		if res == nil {
+			return nil, err
+		}
+
+		return &Response{Issued: res.Issued, Validations: res.Validations}, err
+	}
+}
+
+// newIssuer returns an Issuer for the request, with the configured
+// destinations and renewal window
+func newIssuer(c *Config, sess *session.Session, cr *CertificateRequest) (*issuer.Issuer, error) {
+	var destinations []issuer.Destination
+	for _, location := range c.Destinations {
+		d, err := newDestination(location, sess)
+		if err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, d)
+	}
+
+	client := &acmeClient{c: c, sess: sess, cr: cr}
+
+	return issuer.New(client, destinations...).WithPolicy(issuer.Window(c.RenewalWindow)), nil
+}
+
+// acmeClient implements issuer.ACMEClient with lego, solving each domain with
+// the challenge rules from the request
+type acmeClient struct {
+	c    *Config
+	sess *session.Session
+	cr   *CertificateRequest
+
+	// How each domain was validated by the last Obtain
+	validations []issuer.Validation
+}
+
+// Obtain implements issuer.ACMEClient
+func (a *acmeClient) Obtain(ctx context.Context, req issuer.Request) (*certificate.Resource, error) {
+	a.validations = nil
+
+	user, err := account(ctx, a.c, a.sess)
+	if err != nil {
+		return nil, err
+	}
+	config := lego.NewConfig(user)
+
+	// The challenges, including their self checks and replication waits, have
+	// to finish in time to complete the order within the invocation
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-finishTime))
+		defer cancel()
+	}
+
+	// Each domain is solved with the challenge type and provider from the
+	// request
+	cs, err := challengeSolver(ctx, a.c, a.sess, a.cr)
+	if err != nil {
+		return nil, err
+	}
+	cs.WithContext(ctx)
+	if err := cs.Validate(req.Domains); err != nil {
+		return nil, err
+	}
+	certifier, err := cs.NewCertifier(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Now let's start the certificate request process with Let's Encrypt
+	cert, err := certifier.Obtain(certificate.ObtainRequest{
+		Domains: req.Domains,
+		Bundle:  false,
+	})
+	for _, result := range certifier.Results() {
+		log.Printf("[INFO] Validated %v", result)
+		a.validations = append(a.validations, validation(result))
+	}
+
+	return cert, err
+}
+
+// Validations implements issuer.ValidationReporter
+func (a *acmeClient) Validations() []issuer.Validation {
+	return a.validations
+}
+
+// validation converts the composite solver's result for a domain
+func validation(r composite.Result) issuer.Validation {
+	v := issuer.Validation{Domain: r.Domain, Reused: r.Reused}
+	if r.Rule != nil {
+		v.Method = r.Rule.String()
+	}
+	for _, f := range r.Failures {
+		v.Failed = append(v.Failed, f.Rule.String())
+	}
+
+	return v
+}
+
+// account returns a registered ACME account, from the AccountStore if there is
+// one. A new account is registered, and saved, if needed.
+func account(ctx context.Context, c *Config, sess *session.Session) (*helpers.User, error) {
+	store, err := newAccountStore(c.AccountStore, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *helpers.User
+	if store != nil {
+		user, err = store.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if user != nil && user.GetRegistration() != nil {
+		log.Printf("[INFO] Using account %v", user.GetRegistration().URI)
+		return user, nil
+	}
+
+	// Create the let's encrypt client
+	user, err = helpers.NewUser(c.UserEmail)
+	if err != nil {
+		return nil, err
+	}
+	client, err := lego.NewClient(lego.NewConfig(user))
+	if err != nil {
+		return nil, err
+	}
+
+	// register our user
+	reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		return nil, err
+	}
+	user.SetRegistration(reg)
+
+	if store != nil {
+		log.Printf("[INFO] Saving new account %v", reg.URI)
+		if err := store.Save(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
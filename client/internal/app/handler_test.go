@@ -0,0 +1,32 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-acme/lego/v4/challenge"
+
+	"github.com/sjauld/acme-sls/helpers"
+	"github.com/sjauld/acme-sls/solver/composite"
+)
+
+func TestValidation(t *testing.T) {
+	dns := composite.Rule{Type: challenge.DNS01, Name: ProviderRoute53}
+	v := validation(composite.Result{
+		Domain:   "example.com",
+		Rule:     &dns,
+		Failures: []composite.Failure{{Rule: composite.Rule{Type: challenge.HTTP01, Name: ProviderS3}, Err: errors.New("404")}},
+	})
+
+	helpers.ExpectStringMatch(t, "example.com", v.Domain)
+	helpers.ExpectStringMatch(t, "dns-01 (route53)", v.Method)
+	helpers.ExpectIntMatch(t, 1, len(v.Failed))
+	helpers.ExpectStringMatch(t, "http-01 (s3)", v.Failed[0])
+
+	// A domain that wasn't validated has no method
+	v = validation(composite.Result{Domain: "example.com", Reused: true})
+	helpers.ExpectStringMatch(t, "", v.Method)
+	if !v.Reused {
+		t.Errorf("Expected the reused authorization to be reported")
+	}
+}
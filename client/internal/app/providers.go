@@ -0,0 +1,276 @@
+package app
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/providers/dns/route53"
+	"github.com/go-redis/redis/v8"
+
+	alpn "github.com/sjauld/acme-sls/solver/acm-tls-alpn"
+	alb "github.com/sjauld/acme-sls/solver/alb-fixed-response"
+	"github.com/sjauld/acme-sls/solver/composite"
+	httpsolver "github.com/sjauld/acme-sls/solver/http"
+	s3solver "github.com/sjauld/acme-sls/solver/http-s3"
+	"github.com/sjauld/acme-sls/solver/selfcheck"
+)
+
+// providerTypes is the challenge type that each provider solves
+var providerTypes = map[string]challenge.Type{
+	ProviderS3:      challenge.HTTP01,
+	ProviderStore:   challenge.HTTP01,
+	ProviderALB:     challenge.HTTP01,
+	ProviderACM:     challenge.TLSALPN01,
+	ProviderRoute53: challenge.DNS01,
+}
+
+// defaultProviders is the provider for a challenge type when a rule doesn't
+// name one, and SOLVER doesn't solve that type
+var defaultProviders = map[challenge.Type]string{
+	challenge.HTTP01:    ProviderS3,
+	challenge.TLSALPN01: ProviderACM,
+	challenge.DNS01:     ProviderRoute53,
+}
+
+// CertificateRequest contains the data we'll send via the CloudWatch event
+// trigger
+type CertificateRequest struct {
+	ID         string          `json:"id"`         // Provide an ID so we can manage certificate rotation in ACM
+	Domains    []string        `json:"domains"`    // A list of domains to request on the certificate
+	Challenges []ChallengeRule `json:"challenges"` // How to solve each domain, in order of preference; by default every domain uses SOLVER
+
+	// Challenge certificates for the acm provider, which are associated with
+	// the custom domains in API Gateway
+	ChallengeCertARN  string            `json:"challengeCertificateARN"`
+	ChallengeCertARNs map[string]string `json:"challengeCertificateARNs"` // Keyed by domain, for domains that have their own
+	// Keyed by domain, ssm:<parameter name> of a SecureString holding the
+	// challenge certificate and key to restore after validation
+	ChallengeCertOriginals map[string]string `json:"challengeCertificateOriginals"`
+}
+
+// ChallengeRule chooses the challenge type and provider for the domains that
+// match a pattern, e.g. {"domain": "*.example.com", "type": "dns-01"}
+type ChallengeRule struct {
+	Domain   string `json:"domain"`   // A domain, *.example.com for every name under example.com, or * for everything
+	Type     string `json:"type"`     // http-01, tls-alpn-01 or dns-01; defaults to the provider's type
+	Provider string `json:"provider"` // s3, store, alb, acm or route53; defaults to SOLVER if it solves the type
+}
+
+// providers builds the challenge providers for a request, each at most once
+type providers struct {
+	ctx  context.Context
+	c    *Config
+	sess *session.Session
+	cr   *CertificateRequest
+
+	built map[string]challenge.Provider
+}
+
+// challengeSolver turns the rules from the request into a composite solver.
+// When several rules match a domain, the later ones are fallbacks. Domains that
+// don't match a rule use SOLVER.
+func challengeSolver(ctx context.Context, c *Config, sess *session.Session, cr *CertificateRequest) (*composite.Solver, error) {
+	p := &providers{ctx: ctx, c: c, sess: sess, cr: cr, built: map[string]challenge.Provider{}}
+
+	rules := append(append([]ChallengeRule{}, cr.Challenges...), ChallengeRule{Domain: "*", Provider: c.Solver})
+
+	cs := composite.New()
+	for _, r := range rules {
+		name, typ, err := p.resolve(r)
+		if err != nil {
+			return nil, err
+		}
+
+		provider, err := p.get(name)
+		if err != nil {
+			return nil, err
+		}
+
+		cs.WithRule(composite.Rule{Pattern: r.Domain, Type: typ, Provider: provider, Name: name})
+	}
+
+	return cs, nil
+}
+
+// resolve fills in the provider or type that a rule leaves out
+func (p *providers) resolve(r ChallengeRule) (string, challenge.Type, error) {
+	name, typ := r.Provider, challenge.Type(r.Type)
+
+	switch {
+	case name == "" && typ == "":
+		name = p.c.Solver
+	case name == "" && typ == providerTypes[p.c.Solver]:
+		name = p.c.Solver
+	case name == "":
+		name = defaultProviders[typ]
+	}
+
+	pt, ok := providerTypes[name]
+	if !ok {
+		return "", "", fmt.Errorf("unsupported challenge for %v: type %q, provider %q", r.Domain, r.Type, r.Provider)
+	}
+	if typ == "" {
+		typ = pt
+	}
+	if typ != pt {
+		return "", "", fmt.Errorf("the %v provider can't solve %v challenges for %v", name, typ, r.Domain)
+	}
+
+	return name, typ, nil
+}
+
+// get returns the named provider, building it the first time
+func (p *providers) get(name string) (challenge.Provider, error) {
+	if provider, ok := p.built[name]; ok {
+		return provider, nil
+	}
+
+	var provider challenge.Provider
+	var err error
+	switch name {
+	case ProviderS3:
+		provider = p.s3()
+	case ProviderStore:
+		provider, err = p.store()
+	case ProviderALB:
+		if p.c.ALBListenerARN == "" {
+			return nil, fmt.Errorf("the %v provider needs ALB_LISTENER_ARN", ProviderALB)
+		}
+		provider = alb.New(elbv2.New(p.sess), p.c.ALBListenerARN).WithContext(p.ctx)
+	case ProviderACM:
+		provider, err = p.acm()
+	case ProviderRoute53:
+		// The Route 53 provider finds the hosted zone for each domain itself,
+		// so one is enough
+		provider, err = route53.NewDNSProvider()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.built[name] = provider
+	return provider, nil
+}
+
+// s3 returns the solver/http-s3 provider
+func (p *providers) s3() challenge.Provider {
+	s3Sess := p.sess.Copy(&aws.Config{Region: aws.String(p.c.S3Region)})
+
+	s := s3solver.New(s3.New(s3Sess)).WithMapper(p.c.S3Mapper).WithACL(p.c.S3ACL).WithDelay(p.c.S3Delay).WithContext(p.ctx)
+	s.WithMirrors(p.replicas(p.c.S3MirrorBuckets)...).WithReplicationCheck(p.replicas(p.c.S3ReplicaBuckets)...).WithReplicationTimeout(p.c.ReplicationTimeout)
+	if p.c.SelfCheckTimeout > 0 {
+		s.WithSelfCheck(selfcheck.New().WithTimeout(p.c.SelfCheckTimeout))
+	} else {
+		s.WithSelfCheck(nil)
+	}
+
+	return s
+}
+
+// replicas turns a comma separated list of bucket[@region] into Replicas, each
+// with an S3 client for its region
+func (p *providers) replicas(s string) []s3solver.Replica {
+	var replicas []s3solver.Replica
+	for _, entry := range splitList(s) {
+		bucket, region := entry, p.c.S3Region
+		if i := strings.LastIndex(entry, "@"); i >= 0 {
+			bucket, region = entry[:i], entry[i+1:]
+		}
+
+		replicas = append(replicas, s3solver.Replica{
+			Client: s3.New(p.sess.Copy(&aws.Config{Region: aws.String(region)})),
+			Mapper: s3solver.SharedBucket(bucket, ""),
+		})
+	}
+
+	return replicas
+}
+
+// store returns the solver/http provider, writing to STORE_BACKEND
+func (p *providers) store() (challenge.Provider, error) {
+	var store httpsolver.Store
+	switch p.c.StoreBackend {
+	case storeRedis:
+		store = httpsolver.NewRedisStore(redis.NewClient(&redis.Options{Addr: p.c.RedisAddr}))
+	case storeS3:
+		if p.c.S3Bucket == "" {
+			return nil, fmt.Errorf("the %v store needs S3_BUCKET_NAME", storeS3)
+		}
+		store = httpsolver.NewS3Store(s3.New(p.sess), p.c.S3Bucket, p.c.S3KeyPrefix)
+	default:
+		store = httpsolver.NewDynamoDBStore(dynamodb.New(p.sess), p.c.DynamoDBTable)
+	}
+
+	return httpsolver.New(store).WithContext(p.ctx), nil
+}
+
+// acm returns the solver/acm-tls-alpn provider, with the request's challenge
+// certificates and their originals
+func (p *providers) acm() (challenge.Provider, error) {
+	s := alpn.New(acm.New(p.sess), p.cr.ChallengeCertARN).WithCertificateARNs(p.cr.ChallengeCertARNs).WithContext(p.ctx)
+	if p.c.SelfCheckTimeout > 0 {
+		s.WithSelfCheck(selfcheck.New().WithTimeout(p.c.SelfCheckTimeout))
+	} else {
+		s.WithSelfCheck(nil)
+	}
+
+	for domain, location := range p.cr.ChallengeCertOriginals {
+		certPEM, keyPEM, err := loadOriginal(p.ctx, ssm.New(p.sess), location)
+		if err != nil {
+			return nil, fmt.Errorf("loading the original challenge certificate for %v: %w", domain, err)
+		}
+		s.WithOriginal(domain, certPEM, keyPEM)
+	}
+
+	return s, nil
+}
+
+// loadOriginal reads a certificate and its key, in PEM, from the SSM
+// SecureString at ssm:<parameter name>
+func loadOriginal(ctx context.Context, client ssmiface.SSMAPI, location string) ([]byte, []byte, error) {
+	if !strings.HasPrefix(location, "ssm:") {
+		return nil, nil, fmt.Errorf("invalid location %q: expected ssm:<name>", location)
+	}
+
+	resp, err := client.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(strings.TrimPrefix(location, "ssm:")),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var certPEM, keyPEM []byte
+	rest := []byte(aws.StringValue(resp.Parameter.Value))
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		// Any chain after the certificate isn't needed by the challenge
+		switch {
+		case strings.HasSuffix(block.Type, "PRIVATE KEY") && keyPEM == nil:
+			keyPEM = pem.EncodeToMemory(block)
+		case block.Type == "CERTIFICATE" && certPEM == nil:
+			certPEM = pem.EncodeToMemory(block)
+		}
+	}
+	if certPEM == nil || keyPEM == nil {
+		return nil, nil, fmt.Errorf("%v needs both a certificate and a private key", location)
+	}
+
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,21 @@
+// lambda is the certificate creation lambda. The solver, challenge store,
+// account store and certificate destinations are chosen by environment
+// variables; see client/internal/app.
+package main
+
+import (
+	"log"
+
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/sjauld/acme-sls/client/internal/app"
+)
+
+func main() {
+	c, err := app.ConfigFromEnv(app.ProviderS3)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lambda.Start(app.Handler(c))
+}
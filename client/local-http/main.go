@@ -87,6 +87,12 @@ func testDynamodbClient() dynamodbiface.DynamoDBAPI {
 		log.Println(err)
 	}
 
+	// Make sure orphaned challenges are cleaned up if we crash mid-order
+	err = solver.EnableDynamoDBTTL(svc, "challenges")
+	if err != nil {
+		log.Println(err)
+	}
+
 	return svc
 }
 
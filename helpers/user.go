@@ -5,7 +5,9 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"encoding/json"
 
+	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/registration"
 )
 
@@ -47,3 +49,39 @@ func (u *User) GetRegistration() *registration.Resource {
 func (u *User) SetRegistration(r *registration.Resource) {
 	u.registration = r
 }
+
+// savedUser is the JSON form of a User, so that an account can be kept between
+// runs instead of registering a new one each time
+type savedUser struct {
+	Email        string                 `json:"email"`
+	Key          string                 `json:"key"`
+	Registration *registration.Resource `json:"registration,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, including the private key as PEM
+func (u *User) MarshalJSON() ([]byte, error) {
+	return json.Marshal(savedUser{
+		Email:        u.email,
+		Key:          string(certcrypto.PEMEncode(u.key)),
+		Registration: u.registration,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (u *User) UnmarshalJSON(data []byte) error {
+	var saved savedUser
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	key, err := certcrypto.ParsePEMPrivateKey([]byte(saved.Key))
+	if err != nil {
+		return err
+	}
+
+	u.email = saved.Email
+	u.key = key
+	u.registration = saved.Registration
+
+	return nil
+}
@@ -1,6 +1,12 @@
 package helpers
 
-import "testing"
+import (
+	"crypto"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-acme/lego/v4/registration"
+)
 
 func TestNewUser(t *testing.T) {
 	testUser, err := NewUser("asd")
@@ -10,3 +16,27 @@ func TestNewUser(t *testing.T) {
 
 	ExpectStringMatch(t, "asd", testUser.GetEmail())
 }
+
+func TestUserJSON(t *testing.T) {
+	testUser, err := NewUser("asd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testUser.SetRegistration(&registration.Resource{URI: "https://ca.test/acct/1"})
+
+	data, err := json.Marshal(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &User{}
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	ExpectStringMatch(t, "asd", loaded.GetEmail())
+	ExpectStringMatch(t, "https://ca.test/acct/1", loaded.GetRegistration().URI)
+	if !testUser.key.(interface{ Equal(crypto.PrivateKey) bool }).Equal(loaded.GetPrivateKey()) {
+		t.Errorf("The private key didn't survive the round trip")
+	}
+}
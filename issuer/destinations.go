@@ -0,0 +1,163 @@
+package issuer
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// ACMTagName is the tag on ACM certificates whose value is the request ID
+const ACMTagName = "ACME-SLS-Certificate-ID"
+
+// splitCertificate returns the leaf certificate and the chain. Even though we
+// ask for Bundle: false, we seem to get a bundled certificate, so we need to
+// unbundle it first.
+func splitCertificate(cert *certificate.Resource) ([]byte, []byte) {
+	return helpers.CertFromChain(cert.Certificate), cert.IssuerCertificate
+}
+
+// acmDestination imports certificates into ACM, tagged with the request ID
+type acmDestination struct {
+	client acmiface.ACMAPI
+}
+
+// NewACMDestination returns a Destination that imports certificates into ACM,
+// tagged with ACMTagName so that they can be found again
+func NewACMDestination(client acmiface.ACMAPI) Destination {
+	return &acmDestination{client: client}
+}
+
+func (d *acmDestination) Validity(ctx context.Context, req Request) (time.Duration, error) {
+	_, validity, err := helpers.CertificateDetails(d.client, req.Domains[0], req.ID, ACMTagName)
+	return validity, err
+}
+
+func (d *acmDestination) Import(ctx context.Context, req Request, cert *certificate.Resource) error {
+	certARN, _, err := helpers.CertificateDetails(d.client, req.Domains[0], req.ID, ACMTagName)
+	if err != nil {
+		return err
+	}
+
+	leaf, chain := splitCertificate(cert)
+	in := &acm.ImportCertificateInput{
+		Certificate:      leaf,
+		CertificateChain: chain,
+		PrivateKey:       cert.PrivateKey,
+	}
+	// ACM doesn't allow tags when a certificate is re-imported
+	if certARN != "" {
+		log.Printf("[INFO] Renewing ACM certificate %v", certARN)
+		in.CertificateArn = aws.String(certARN)
+	} else {
+		log.Printf("[INFO] Creating new ACM certificate")
+		in.Tags = []*acm.Tag{
+			{
+				Key:   aws.String(ACMTagName),
+				Value: aws.String(req.ID),
+			},
+		}
+	}
+
+	resp, err := d.client.ImportCertificateWithContext(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] ACM created/renewed: %v", aws.StringValue(resp.CertificateArn))
+	return nil
+}
+
+// s3Destination writes certificates to S3 as PEM files, under the request ID
+// (or the first domain if there isn't one)
+type s3Destination struct {
+	client s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// NewS3Destination returns a Destination that writes cert.pem, chain.pem,
+// fullchain.pem and privkey.pem to the bucket, under prefix/<ID>/
+func NewS3Destination(client s3iface.S3API, bucket, prefix string) Destination {
+	return &s3Destination{client: client, bucket: bucket, prefix: prefix}
+}
+
+// key returns the key of one of the files for the request
+func (d *s3Destination) key(req Request, file string) string {
+	return path.Join(d.prefix, req.name(), file)
+}
+
+func (d *s3Destination) Validity(ctx context.Context, req Request) (time.Duration, error) {
+	resp, err := d.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(d.key(req, "cert.pem")),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return 0, errors.New("no certificate in " + d.key(req, "cert.pem"))
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Until(cert.NotAfter), nil
+}
+
+func (d *s3Destination) Import(ctx context.Context, req Request, cert *certificate.Resource) error {
+	leaf, chain := splitCertificate(cert)
+
+	// The certificate is written last, as Validity reads it
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"privkey.pem", cert.PrivateKey},
+		{"chain.pem", chain},
+		{"fullchain.pem", append(append(append([]byte{}, leaf...), '\n'), chain...)},
+		{"cert.pem", leaf},
+	}
+	for _, f := range files {
+		_, err := d.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket:               aws.String(d.bucket),
+			Key:                  aws.String(d.key(req, f.name)),
+			Body:                 bytes.NewReader(f.data),
+			ContentType:          aws.String("application/x-pem-file"),
+			ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[INFO] Certificate written to s3://%v/%v", d.bucket, d.key(req, ""))
+	return nil
+}
@@ -0,0 +1,225 @@
+package issuer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// testCertificate returns a self-signed certificate for example.com that
+// expires in validity
+func testCertificate(t *testing.T, validity time.Duration) *certificate.Resource {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotAfter:     time.Now().Add(validity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return &certificate.Resource{
+		Domain:            "example.com",
+		Certificate:       certPEM,
+		IssuerCertificate: certPEM,
+		PrivateKey:        pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// fakeACM keeps imported certificates in memory, keyed by ARN
+type fakeACM struct {
+	acmiface.ACMAPI
+	certs map[string]*acm.ImportCertificateInput
+	tags  map[string][]*acm.Tag
+	next  int
+}
+
+func newFakeACM() *fakeACM {
+	return &fakeACM{
+		certs: map[string]*acm.ImportCertificateInput{},
+		tags:  map[string][]*acm.Tag{},
+	}
+}
+
+// parse returns the certificate stored under arn
+func (f *fakeACM) parse(arn string) (*x509.Certificate, error) {
+	block, _ := pem.Decode(f.certs[arn].Certificate)
+	if block == nil {
+		return nil, fmt.Errorf("no certificate for %v", arn)
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func (f *fakeACM) ListCertificates(in *acm.ListCertificatesInput) (*acm.ListCertificatesOutput, error) {
+	out := &acm.ListCertificatesOutput{}
+	for arn := range f.certs {
+		cert, err := f.parse(arn)
+		if err != nil {
+			return nil, err
+		}
+		out.CertificateSummaryList = append(out.CertificateSummaryList, &acm.CertificateSummary{
+			CertificateArn: aws.String(arn),
+			DomainName:     aws.String(cert.Subject.CommonName),
+		})
+	}
+
+	return out, nil
+}
+
+func (f *fakeACM) ListTagsForCertificate(in *acm.ListTagsForCertificateInput) (*acm.ListTagsForCertificateOutput, error) {
+	return &acm.ListTagsForCertificateOutput{Tags: f.tags[aws.StringValue(in.CertificateArn)]}, nil
+}
+
+func (f *fakeACM) DescribeCertificate(in *acm.DescribeCertificateInput) (*acm.DescribeCertificateOutput, error) {
+	cert, err := f.parse(aws.StringValue(in.CertificateArn))
+	if err != nil {
+		return nil, err
+	}
+
+	return &acm.DescribeCertificateOutput{Certificate: &acm.CertificateDetail{NotAfter: aws.Time(cert.NotAfter)}}, nil
+}
+
+func (f *fakeACM) ImportCertificateWithContext(ctx aws.Context, in *acm.ImportCertificateInput, opts ...request.Option) (*acm.ImportCertificateOutput, error) {
+	arn := aws.StringValue(in.CertificateArn)
+	if arn == "" {
+		f.next++
+		arn = fmt.Sprintf("arn:aws:acm:us-east-1:123456789012:certificate/%d", f.next)
+		f.tags[arn] = in.Tags
+	} else if len(in.Tags) > 0 {
+		return nil, awserr.New(acm.ErrCodeInvalidParameterException, "tags can't be applied when reimporting", nil)
+	}
+	f.certs[arn] = in
+
+	return &acm.ImportCertificateOutput{CertificateArn: aws.String(arn)}, nil
+}
+
+func TestACMDestination(t *testing.T) {
+	f := newFakeACM()
+	d := NewACMDestination(f)
+	req := Request{ID: "example", Domains: []string{"example.com"}}
+
+	validity, err := d.Validity(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validity != 0 {
+		t.Errorf("Expected no validity, got %v", validity)
+	}
+
+	// The first import is tagged with the ID
+	if err := d.Import(context.Background(), req, testCertificate(t, 24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 1, len(f.certs))
+	for _, tags := range f.tags {
+		helpers.ExpectStringMatch(t, ACMTagName, aws.StringValue(tags[0].Key))
+		helpers.ExpectStringMatch(t, "example", aws.StringValue(tags[0].Value))
+	}
+
+	// The renewal replaces it
+	if err := d.Import(context.Background(), req, testCertificate(t, 90*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 1, len(f.certs))
+
+	validity, err = d.Validity(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validity < 89*24*time.Hour {
+		t.Errorf("Expected the renewed certificate, got %v remaining", validity)
+	}
+}
+
+// fakeS3 keeps objects in memory, keyed by bucket/key
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	b, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)] = b
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	b, ok := f.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+	}
+
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func TestS3Destination(t *testing.T) {
+	f := &fakeS3{objects: map[string][]byte{}}
+	d := NewS3Destination(f, "bucket", "certs")
+	req := Request{Domains: []string{"example.com"}}
+
+	// There's no certificate to begin with
+	validity, err := d.Validity(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validity != 0 {
+		t.Errorf("Expected no validity, got %v", validity)
+	}
+
+	// Without an ID the files are named after the first domain
+	if err := d.Import(context.Background(), req, testCertificate(t, 48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"cert.pem", "chain.pem", "fullchain.pem", "privkey.pem"} {
+		if _, ok := f.objects["bucket/certs/example.com/"+file]; !ok {
+			t.Errorf("Expected %v to be written", file)
+		}
+	}
+
+	validity, err = d.Validity(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validity < 47*time.Hour || validity > 48*time.Hour {
+		t.Errorf("Expected about 48h, got %v", validity)
+	}
+}
@@ -0,0 +1,208 @@
+// package issuer obtains certificates from an ACME CA and imports them into
+// their destinations, renewing them when they're close to expiry. The ACME
+// client, renewal policy and destinations are interfaces, so that the lambdas
+// and your own tools can plug in their own.
+package issuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// DefaultRenewalWindow is how much validity a certificate has left when it is
+// renewed, unless you set a RenewalPolicy
+const DefaultRenewalWindow = 7 * 24 * time.Hour
+
+// Request is a certificate to issue or renew
+type Request struct {
+	// ID identifies the certificate in its destinations, e.g. the value of the
+	// tag on an ACM certificate. Without one the existing certificate can't be
+	// found, so Renew always issues.
+	ID string
+	// Domains are the names on the certificate. The first is the common name.
+	Domains []string
+}
+
+// name is how the certificate is named in destinations that don't have tags
+func (r Request) name() string {
+	if r.ID != "" {
+		return r.ID
+	}
+
+	return r.Domains[0]
+}
+
+// ACMEClient obtains certificates from the CA, e.g. a lego Certifier with the
+// challenge providers set up for the request
+type ACMEClient interface {
+	Obtain(ctx context.Context, req Request) (*certificate.Resource, error)
+}
+
+// Validation records how the CA validated one of the domains
+type Validation struct {
+	Domain string `json:"domain"`
+	// Method is the challenge that validated the domain, e.g. "http-01 (s3)",
+	// or "" if it wasn't validated
+	Method string `json:"method,omitempty"`
+	// Reused is true if the CA already had a valid authorization
+	Reused bool `json:"reused,omitempty"`
+	// Failed are the methods that were tried first, in order
+	Failed []string `json:"failed,omitempty"`
+}
+
+// ValidationReporter is an ACMEClient that can say how each domain was
+// validated by its last Obtain, e.g. when some domains fall back to another
+// challenge
+type ValidationReporter interface {
+	Validations() []Validation
+}
+
+// RenewalPolicy decides whether a certificate needs renewing
+type RenewalPolicy interface {
+	// ShouldRenew is given the least validity left in any destination, which
+	// is 0 if a destination doesn't have the certificate
+	ShouldRenew(req Request, remaining time.Duration) bool
+}
+
+// Window is a RenewalPolicy that renews certificates with less than the
+// duration left
+type Window time.Duration
+
+// ShouldRenew implements RenewalPolicy
+func (w Window) ShouldRenew(req Request, remaining time.Duration) bool {
+	return remaining <= time.Duration(w)
+}
+
+// Destination is somewhere that certificates are imported to
+type Destination interface {
+	// Validity returns how long the current certificate for the request has
+	// left, or 0 if there isn't one
+	Validity(ctx context.Context, req Request) (time.Duration, error)
+	// Import creates or replaces the certificate for the request
+	Import(ctx context.Context, req Request, cert *certificate.Resource) error
+}
+
+// Result describes what Issue or Renew did
+type Result struct {
+	// Issued is true if a new certificate was obtained and imported
+	Issued bool
+	// Remaining is the least validity that was left in any destination, if
+	// Renew checked
+	Remaining time.Duration
+	// Certificate is the new certificate, if one was obtained
+	Certificate *certificate.Resource
+	// Validations describe how each domain was validated, if the ACMEClient is
+	// a ValidationReporter
+	Validations []Validation
+}
+
+// Issuer issues certificates with an ACMEClient and imports them into each
+// Destination
+type Issuer struct {
+	client       ACMEClient
+	policy       RenewalPolicy
+	destinations []Destination
+}
+
+// New returns a pointer to an Issuer, which renews certificates within
+// DefaultRenewalWindow of expiry
+func New(client ACMEClient, destinations ...Destination) *Issuer {
+	return &Issuer{
+		client:       client,
+		policy:       Window(DefaultRenewalWindow),
+		destinations: destinations,
+	}
+}
+
+// WithPolicy allows you to override the RenewalPolicy
+func (i *Issuer) WithPolicy(p RenewalPolicy) *Issuer {
+	i.policy = p
+	return i
+}
+
+// Issue obtains a certificate and imports it into every destination. The
+// Result has the Validations even if the certificate couldn't be obtained.
+func (i *Issuer) Issue(ctx context.Context, req Request) (*Result, error) {
+	if len(req.Domains) == 0 {
+		return nil, errors.New("you need to provide at least one domain")
+	}
+
+	log.Printf("[INFO] Requesting certificate for: %v", req.Domains)
+	cert, err := i.client.Obtain(ctx, req)
+
+	res := &Result{}
+	if vr, ok := i.client.(ValidationReporter); ok {
+		res.Validations = vr.Validations()
+	}
+	if err != nil {
+		return res, err
+	}
+	log.Printf("[INFO] Obtained certificate: %v", cert.CertURL)
+
+	res.Issued = true
+	res.Certificate = cert
+
+	// Import into the other destinations even if one fails, so that they don't
+	// all miss out
+	var failed []error
+	for _, d := range i.destinations {
+		if err := d.Import(ctx, req, cert); err != nil {
+			log.Printf("[ERROR] importing certificate: %v", err)
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return res, fmt.Errorf("importing into %d of %d destinations failed, the first with: %w", len(failed), len(i.destinations), failed[0])
+	}
+
+	return res, nil
+}
+
+// Renew issues a certificate if the RenewalPolicy says that it's needed
+func (i *Issuer) Renew(ctx context.Context, req Request) (*Result, error) {
+	if len(req.Domains) == 0 {
+		return nil, errors.New("you need to provide at least one domain")
+	}
+	if req.ID == "" {
+		return i.Issue(ctx, req)
+	}
+
+	remaining, err := i.remaining(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !i.policy.ShouldRenew(req, remaining) {
+		log.Printf("[INFO] Not renewing because certificate still has %v remaining", remaining)
+		return &Result{Remaining: remaining}, nil
+	}
+
+	res, err := i.Issue(ctx, req)
+	if res != nil {
+		res.Remaining = remaining
+	}
+
+	return res, err
+}
+
+// remaining returns the least validity left in any destination
+func (i *Issuer) remaining(ctx context.Context, req Request) (time.Duration, error) {
+	var remaining time.Duration
+	for n, d := range i.destinations {
+		validity, err := d.Validity(ctx, req)
+		if err != nil {
+			return 0, err
+		}
+
+		if n == 0 || validity < remaining {
+			remaining = validity
+		}
+	}
+
+	return remaining, nil
+}
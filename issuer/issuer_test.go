@@ -0,0 +1,175 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// fakeACMEClient stands in for lego, returning a test certificate
+type fakeACMEClient struct {
+	t        *testing.T
+	requests []Request
+	err      error
+}
+
+func (f *fakeACMEClient) Obtain(ctx context.Context, req Request) (*certificate.Resource, error) {
+	f.requests = append(f.requests, req)
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return testCertificate(f.t, 90*24*time.Hour), nil
+}
+
+// reportingClient is a fakeACMEClient that reports how the domains were
+// validated
+type reportingClient struct {
+	fakeACMEClient
+}
+
+func (r *reportingClient) Validations() []Validation {
+	return []Validation{{Domain: "example.com", Method: "dns-01 (route53)", Failed: []string{"http-01 (s3)"}}}
+}
+
+// fakeDestination keeps the last certificate imported
+type fakeDestination struct {
+	validity  time.Duration
+	imported  *certificate.Resource
+	importErr error
+}
+
+func (f *fakeDestination) Validity(ctx context.Context, req Request) (time.Duration, error) {
+	return f.validity, nil
+}
+
+func (f *fakeDestination) Import(ctx context.Context, req Request, cert *certificate.Resource) error {
+	if f.importErr != nil {
+		return f.importErr
+	}
+	f.imported = cert
+
+	return nil
+}
+
+func TestIssue(t *testing.T) {
+	client := &fakeACMEClient{t: t}
+	a, b := &fakeDestination{}, &fakeDestination{}
+
+	res, err := New(client, a, b).Issue(context.Background(), Request{ID: "example", Domains: []string{"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !res.Issued || res.Certificate == nil {
+		t.Errorf("Expected a certificate to be issued")
+	}
+	if a.imported != res.Certificate || b.imported != res.Certificate {
+		t.Errorf("Expected the certificate to be imported into every destination")
+	}
+	helpers.ExpectStringMatch(t, "example", client.requests[0].ID)
+}
+
+func TestIssue_errors(t *testing.T) {
+	// Nothing to do
+	if _, err := New(&fakeACMEClient{t: t}).Issue(context.Background(), Request{}); err == nil {
+		t.Errorf("Expected an error without domains")
+	}
+
+	// The CA fails
+	client := &fakeACMEClient{t: t, err: errors.New("rate limited")}
+	d := &fakeDestination{}
+	if _, err := New(client, d).Issue(context.Background(), Request{Domains: []string{"example.com"}}); err == nil {
+		t.Errorf("Expected the error from the ACME client")
+	}
+	if d.imported != nil {
+		t.Errorf("Nothing should be imported when the CA fails")
+	}
+
+	// One destination fails, but the other still gets the certificate
+	failing := &fakeDestination{importErr: errors.New("access denied")}
+	res, err := New(&fakeACMEClient{t: t}, failing, d).Issue(context.Background(), Request{Domains: []string{"example.com"}})
+	if err == nil {
+		t.Errorf("Expected an error when a destination fails")
+	}
+	if res == nil || d.imported != res.Certificate {
+		t.Errorf("Expected the certificate to reach the working destination")
+	}
+}
+
+func TestIssue_validations(t *testing.T) {
+	client := &reportingClient{fakeACMEClient{t: t}}
+
+	res, err := New(client).Issue(context.Background(), Request{Domains: []string{"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 1, len(res.Validations))
+	helpers.ExpectStringMatch(t, "dns-01 (route53)", res.Validations[0].Method)
+
+	// The validations are reported when the order fails too
+	client.err = errors.New("validation failed")
+	res, err = New(client).Issue(context.Background(), Request{Domains: []string{"example.com"}})
+	if err == nil {
+		t.Fatal("Expected the error from the ACME client")
+	}
+	if res == nil || len(res.Validations) != 1 || res.Issued {
+		t.Errorf("Expected the validations without a certificate, got %+v", res)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		validity []time.Duration
+		issued   bool
+	}{
+		{"valid", "example", []time.Duration{30 * 24 * time.Hour}, false},
+		{"expiring", "example", []time.Duration{24 * time.Hour}, true},
+		{"missing from a destination", "example", []time.Duration{30 * 24 * time.Hour, 0}, true},
+		{"no ID", "", []time.Duration{30 * 24 * time.Hour}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var destinations []Destination
+			for _, v := range tt.validity {
+				destinations = append(destinations, &fakeDestination{validity: v})
+			}
+			client := &fakeACMEClient{t: t}
+
+			res, err := New(client, destinations...).Renew(context.Background(), Request{ID: tt.id, Domains: []string{"example.com"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.Issued != tt.issued {
+				t.Errorf("Expected issued to be %v", tt.issued)
+			}
+			helpers.ExpectIntMatch(t, len(client.requests), map[bool]int{true: 1, false: 0}[tt.issued])
+		})
+	}
+}
+
+func TestRenew_policy(t *testing.T) {
+	d := &fakeDestination{validity: 30 * 24 * time.Hour}
+	client := &fakeACMEClient{t: t}
+
+	// A wider window renews the same certificate
+	res, err := New(client, d).WithPolicy(Window(60*24*time.Hour)).Renew(context.Background(), Request{ID: "example", Domains: []string{"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Issued {
+		t.Errorf("Expected the certificate to be renewed")
+	}
+	if res.Remaining != 30*24*time.Hour {
+		t.Errorf("Expected %v remaining, got %v", 30*24*time.Hour, res.Remaining)
+	}
+}
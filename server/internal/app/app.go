@@ -0,0 +1,297 @@
+// package app sets up the challenge servers. The Store, health check, challenge
+// route and redirects are configured the same way by server/lambda,
+// server/local and server/tls-alpn.
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+
+	solver "github.com/sjauld/acme-sls/solver/http"
+)
+
+// Store backends
+const (
+	StoreDynamoDB = "dynamodb"
+	StoreS3       = "s3"
+	StoreRedis    = "redis"
+)
+
+// Config holds the settings shared by the servers
+type Config struct {
+	// The challenge Store
+	Store            string
+	DynamoDBTable    string
+	DynamoDBEndpoint string
+	Region           string
+	S3Bucket         string
+	S3KeyPrefix      string
+	RedisAddr        string
+
+	HealthCheckInterval time.Duration
+
+	// Each client IP can make RateLimit requests per second, in bursts of up
+	// to RateLimitBurst, and misses are cached for NegativeCacheTTL
+	RateLimit        float64
+	RateLimitBurst   int
+	NegativeCacheTTL time.Duration
+	// TrustedProxies are the addresses or CIDRs whose X-Forwarded-For header
+	// identifies the client. By default no proxy is trusted, and clients are
+	// identified by the remote address.
+	TrustedProxies []string
+
+	// Misses are forwarded to UpstreamURL if it is set
+	UpstreamURL     string
+	UpstreamTimeout time.Duration
+
+	// Everything other than the challenge and health check routes is
+	// redirected to https if RedirectToHTTPS is set
+	RedirectToHTTPS      bool
+	RedirectStatusCode   int
+	RedirectAllowedHosts []string
+}
+
+// ConfigFromEnv reads the Config from the environment. Store settings that
+// are missing are left for Validate to report, so that the caller can fill
+// them in first.
+func ConfigFromEnv() (*Config, error) {
+	c := &Config{
+		Store:            os.Getenv("STORE_BACKEND"),
+		DynamoDBTable:    os.Getenv("DYNAMODB_TABLE_NAME"),
+		DynamoDBEndpoint: os.Getenv("DYNAMODB_ENDPOINT"),
+		Region:           os.Getenv("AWS_REGION"),
+		S3KeyPrefix:      os.Getenv("S3_KEY_PREFIX"),
+		RedisAddr:        os.Getenv("REDIS_ADDR"),
+		UpstreamURL:      os.Getenv("UPSTREAM_RESPONDER_URL"),
+		RedirectToHTTPS:  os.Getenv("REDIRECT_TO_HTTPS") == "true",
+	}
+
+	// Challenges are read from S3 rather than DynamoDB if S3_BUCKET_NAME is set
+	var ok bool
+	if c.S3Bucket, ok = os.LookupEnv("S3_BUCKET_NAME"); ok && c.Store == "" {
+		c.Store = StoreS3
+	}
+	if c.Store == "" {
+		c.Store = StoreDynamoDB
+	}
+
+	if s := os.Getenv("REDIRECT_ALLOWED_HOSTS"); s != "" {
+		c.RedirectAllowedHosts = strings.Split(s, ",")
+	}
+	if s := os.Getenv("TRUSTED_PROXIES"); s != "" {
+		c.TrustedProxies = strings.Split(s, ",")
+	}
+
+	var err error
+	if c.HealthCheckInterval, err = EnvDuration("HEALTH_CHECK_INTERVAL", solver.DefaultHealthCheckInterval); err != nil {
+		return nil, err
+	}
+	if c.NegativeCacheTTL, err = EnvDuration("NEGATIVE_CACHE_TTL", solver.DefaultNegativeCacheTTL); err != nil {
+		return nil, err
+	}
+	if c.UpstreamTimeout, err = EnvDuration("UPSTREAM_RESPONDER_TIMEOUT", 0); err != nil {
+		return nil, err
+	}
+
+	c.RateLimit = 5
+	if s, ok := os.LookupEnv("RATE_LIMIT_PER_SECOND"); ok {
+		if c.RateLimit, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_PER_SECOND: %w", err)
+		}
+	}
+	if c.RateLimitBurst, err = envInt("RATE_LIMIT_BURST", 20); err != nil {
+		return nil, err
+	}
+	if c.RedirectStatusCode, err = envInt("REDIRECT_STATUS_CODE", http.StatusPermanentRedirect); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Validate checks that the settings are consistent
+func (c *Config) Validate() error {
+	switch c.Store {
+	case StoreDynamoDB:
+		if c.DynamoDBTable == "" {
+			return fmt.Errorf("the %v store needs a table name (DYNAMODB_TABLE_NAME)", c.Store)
+		}
+	case StoreS3:
+		if c.S3Bucket == "" {
+			return fmt.Errorf("the %v store needs a bucket name (S3_BUCKET_NAME)", c.Store)
+		}
+	case StoreRedis:
+		if c.RedisAddr == "" {
+			return fmt.Errorf("the %v store needs an address (REDIS_ADDR)", c.Store)
+		}
+	default:
+		return fmt.Errorf("unknown store %q", c.Store)
+	}
+
+	if c.UpstreamURL != "" {
+		if _, err := url.Parse(c.UpstreamURL); err != nil {
+			return fmt.Errorf("invalid upstream responder URL: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// NewStore sets up the configured Store. AWS credentials come from the usual
+// environment variables or config files.
+func NewStore(c *Config) (solver.Store, error) {
+	if c.Store == StoreRedis {
+		log.Printf("[INFO] using Redis at %v", c.RedisAddr)
+		return solver.NewRedisStore(redis.NewClient(&redis.Options{Addr: c.RedisAddr})), nil
+	}
+
+	awsConfig := aws.NewConfig()
+	if c.Region != "" {
+		awsConfig = awsConfig.WithRegion(c.Region)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Store == StoreS3 {
+		log.Printf("[INFO] using S3 bucket %v", c.S3Bucket)
+		return solver.NewS3Store(s3.New(sess), c.S3Bucket, c.S3KeyPrefix), nil
+	}
+
+	dynamoDBConfig := aws.NewConfig()
+	if c.DynamoDBEndpoint != "" {
+		dynamoDBConfig = dynamoDBConfig.WithEndpoint(c.DynamoDBEndpoint)
+	}
+
+	log.Printf("[INFO] using DynamoDB table %v", c.DynamoDBTable)
+	return solver.NewDynamoDBStore(dynamodb.New(sess, dynamoDBConfig), c.DynamoDBTable), nil
+}
+
+// Router returns the gin engine serving the health checks and the challenge
+// route, and redirecting everything else if that is configured
+func Router(c *Config, store solver.Store, m solver.Metrics) (*gin.Engine, error) {
+	r := gin.New()
+	// gin trusts X-Forwarded-For from every peer unless told otherwise
+	if err := r.SetTrustedProxies(c.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	// Add middleware
+	r.Use(gin.Logger())
+	r.Use(gin.Recovery())
+
+	r.GET("/hc", func(c *gin.Context) {
+		c.String(http.StatusOK, "OK")
+	})
+	r.GET("/ready", solver.NewHealthCheck(store, c.HealthCheckInterval).GinHandlerFunc())
+
+	handlers, err := ChallengeHandlers(c, store, m)
+	if err != nil {
+		return nil, err
+	}
+	r.GET("/.well-known/acme-challenge/:token", handlers...)
+
+	h, err := RedirectHandler(c)
+	if err != nil {
+		return nil, err
+	}
+	if h != nil {
+		r.NoRoute(gin.WrapH(h))
+	}
+
+	return r, nil
+}
+
+// ChallengeHandlers returns the middleware and handler for the challenge route.
+// Requests with an invalid token are rejected before we look at the Store, each
+// client IP is rate limited, and misses are cached briefly.
+func ChallengeHandlers(c *Config, store solver.Store, m solver.Metrics) ([]gin.HandlerFunc, error) {
+	r, err := NewResponder(c, solver.NewNegativeCacheStore(store, c.NegativeCacheTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	rl := solver.NewRateLimiter(c.RateLimit, c.RateLimitBurst).WithMetrics(m)
+	if len(c.TrustedProxies) > 0 {
+		rl.WithForwardedFor()
+	}
+
+	return []gin.HandlerFunc{
+		solver.NewGinTokenValidator(),
+		rl.GinHandlerFunc(),
+		r.WithMetrics(m).GinHandlerFunc(),
+	}, nil
+}
+
+// NewResponder returns a Responder for the store, forwarding misses to the
+// upstream responder if there is one
+func NewResponder(c *Config, store solver.Store) (*solver.Responder, error) {
+	r := solver.NewResponder(store)
+	if c.UpstreamURL == "" {
+		return r, nil
+	}
+
+	u, err := url.Parse(c.UpstreamURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream responder URL: %w", err)
+	}
+
+	return r.WithUpstream(u, c.UpstreamTimeout), nil
+}
+
+// RedirectHandler returns a handler that redirects to https, or nil if
+// RedirectToHTTPS isn't set
+func RedirectHandler(c *Config) (http.Handler, error) {
+	if !c.RedirectToHTTPS {
+		return nil, nil
+	}
+
+	return solver.NewRedirectHandler(c.RedirectStatusCode, c.RedirectAllowedHosts)
+}
+
+// EnvDuration parses a duration from an environment variable, returning def if
+// it is unset
+func EnvDuration(key string, def time.Duration) (time.Duration, error) {
+	s, ok := os.LookupEnv(key)
+	if !ok {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v: %w", key, err)
+	}
+
+	return d, nil
+}
+
+// envInt parses an integer from an environment variable, returning def if it
+// is unset
+func envInt(key string, def int) (int, error) {
+	s, ok := os.LookupEnv(key)
+	if !ok {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v: %w", key, err)
+	}
+
+	return i, nil
+}
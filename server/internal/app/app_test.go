@@ -0,0 +1,89 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sjauld/acme-sls/helpers"
+	solver "github.com/sjauld/acme-sls/solver/http"
+)
+
+func TestConfigFromEnv_defaults(t *testing.T) {
+	c, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, StoreDynamoDB, c.Store)
+	helpers.ExpectIntMatch(t, 20, c.RateLimitBurst)
+	helpers.ExpectIntMatch(t, http.StatusPermanentRedirect, c.RedirectStatusCode)
+	if c.HealthCheckInterval != solver.DefaultHealthCheckInterval {
+		t.Errorf("Expected %v, got %v", solver.DefaultHealthCheckInterval, c.HealthCheckInterval)
+	}
+
+	// DynamoDB needs a table
+	if err := c.Validate(); err == nil {
+		t.Errorf("Expected an error without DYNAMODB_TABLE_NAME")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("S3_BUCKET_NAME", "bucket")
+	t.Setenv("RATE_LIMIT_PER_SECOND", "0.5")
+	t.Setenv("NEGATIVE_CACHE_TTL", "2s")
+	t.Setenv("REDIRECT_TO_HTTPS", "true")
+	t.Setenv("REDIRECT_ALLOWED_HOSTS", "example.com,www.example.com")
+
+	c, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, StoreS3, c.Store)
+	helpers.ExpectIntMatch(t, 2, len(c.RedirectAllowedHosts))
+	if c.RateLimit != 0.5 {
+		t.Errorf("Expected 0.5, got %v", c.RateLimit)
+	}
+	if c.NegativeCacheTTL != 2*time.Second {
+		t.Errorf("Expected %v, got %v", 2*time.Second, c.NegativeCacheTTL)
+	}
+
+	h, err := RedirectHandler(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h == nil {
+		t.Errorf("Expected a redirect handler")
+	}
+}
+
+func TestConfigFromEnv_invalid(t *testing.T) {
+	for _, key := range []string{"HEALTH_CHECK_INTERVAL", "RATE_LIMIT_PER_SECOND", "RATE_LIMIT_BURST", "NEGATIVE_CACHE_TTL", "UPSTREAM_RESPONDER_TIMEOUT", "REDIRECT_STATUS_CODE"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, "soon")
+
+			if _, err := ConfigFromEnv(); err == nil {
+				t.Errorf("Expected an error for an invalid %v", key)
+			}
+		})
+	}
+}
+
+func TestRouter_trustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,192.0.2.1")
+
+	c, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 2, len(c.TrustedProxies))
+
+	c.TrustedProxies = []string{"not a proxy"}
+	if _, err := Router(c, nil, solver.ExpvarMetrics{}); err == nil {
+		t.Errorf("Expected an error for an invalid trusted proxy")
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/apex/gateway"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// multiGateway is a lambda.Handler that serves an http.Handler from API Gateway
+// REST proxy events, ALB target group events and Lambda Function URL / API Gateway
+// HTTP API (payload v2) events. Unlike API Gateway, an ALB or a Function URL behind
+// CloudFront can listen on port 80, which is what the remote CA needs.
+type multiGateway struct {
+	h    http.Handler
+	rest *gateway.Gateway
+}
+
+func newMultiGateway(h http.Handler) *multiGateway {
+	h = withRemotePort(h)
+
+	return &multiGateway{
+		h:    h,
+		rest: gateway.NewGateway(h),
+	}
+}
+
+// withRemotePort adds a port to the bare source IP that the events give us, as
+// RemoteAddr is host:port for a server request and gin ignores it otherwise
+func withRemotePort(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, _, err := net.SplitHostPort(req.RemoteAddr); err != nil && req.RemoteAddr != "" {
+			req.RemoteAddr = net.JoinHostPort(req.RemoteAddr, "0")
+		}
+
+		h.ServeHTTP(w, req)
+	})
+}
+
+// eventProbe contains just enough of each event format to tell them apart
+type eventProbe struct {
+	Version        string `json:"version"`
+	RequestContext struct {
+		ELB  json.RawMessage `json:"elb"`
+		HTTP json.RawMessage `json:"http"`
+	} `json:"requestContext"`
+}
+
+// Invoke implements lambda.Handler
+func (mg *multiGateway) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	var probe eventProbe
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case probe.RequestContext.ELB != nil:
+		return mg.invokeALB(ctx, payload)
+	case probe.Version == "2.0" || probe.RequestContext.HTTP != nil:
+		return mg.invokeHTTPv2(ctx, payload)
+	default:
+		return mg.rest.Invoke(ctx, payload)
+	}
+}
+
+func (mg *multiGateway) invokeALB(ctx context.Context, payload []byte) ([]byte, error) {
+	var e events.ALBTargetGroupRequest
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, err
+	}
+
+	// The ALB doesn't decode the query string, so we can pass it straight
+	// through. The event's maps lose the order of the parameters, so read them
+	// again in the order they arrived.
+	var q albQuery
+	if err := json.Unmarshal(payload, &q); err != nil {
+		return nil, err
+	}
+	query := q.Single
+	if e.MultiValueQueryStringParameters != nil {
+		query = q.Multi
+	}
+
+	header := http.Header{}
+	if e.MultiValueHeaders != nil {
+		for k, values := range e.MultiValueHeaders {
+			for _, v := range values {
+				header.Add(k, v)
+			}
+		}
+	} else {
+		for k, v := range e.Headers {
+			header.Set(k, v)
+		}
+	}
+
+	req, err := newRequest(ctx, e.HTTPMethod, e.Path, strings.Join(query, "&"), header, e.Body, e.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+	// The ALB appends the address that connected to it, so only the last entry
+	// can be trusted
+	if forwardedFor := header.Values("X-Forwarded-For"); len(forwardedFor) > 0 {
+		entries := strings.Split(forwardedFor[len(forwardedFor)-1], ",")
+		req.RemoteAddr = strings.TrimSpace(entries[len(entries)-1])
+	}
+
+	w := newResponseWriter()
+	mg.h.ServeHTTP(w, req)
+
+	body, isBase64 := w.body()
+	code := w.status()
+	resp := events.ALBTargetGroupResponse{
+		StatusCode:        code,
+		StatusDescription: fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		Body:              body,
+		IsBase64Encoded:   isBase64,
+	}
+
+	// The ALB expects the response headers in the same format as the request
+	if e.MultiValueHeaders != nil {
+		resp.MultiValueHeaders = w.Header()
+	} else {
+		resp.Headers = singleValueHeaders(w.Header())
+	}
+
+	return json.Marshal(&resp)
+}
+
+func (mg *multiGateway) invokeHTTPv2(ctx context.Context, payload []byte) ([]byte, error) {
+	var e events.APIGatewayV2HTTPRequest
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	for k, v := range e.Headers {
+		header.Set(k, v)
+	}
+	if len(e.Cookies) > 0 {
+		header.Set("Cookie", strings.Join(e.Cookies, "; "))
+	}
+	if header.Get("Host") == "" {
+		header.Set("Host", e.RequestContext.DomainName)
+	}
+
+	req, err := newRequest(ctx, e.RequestContext.HTTP.Method, e.RawPath, e.RawQueryString, header, e.Body, e.IsBase64Encoded)
+	if err != nil {
+		return nil, err
+	}
+	req.RemoteAddr = e.RequestContext.HTTP.SourceIP
+	req.Header.Set("X-Request-Id", e.RequestContext.RequestID)
+
+	w := newResponseWriter()
+	mg.h.ServeHTTP(w, req)
+
+	// Cookies have their own field in the v2 response format
+	cookies := w.Header().Values("Set-Cookie")
+	w.Header().Del("Set-Cookie")
+
+	body, isBase64 := w.body()
+	resp := events.APIGatewayV2HTTPResponse{
+		StatusCode:      w.status(),
+		Headers:         singleValueHeaders(w.Header()),
+		Body:            body,
+		IsBase64Encoded: isBase64,
+		Cookies:         cookies,
+	}
+
+	return json.Marshal(&resp)
+}
+
+// newRequest builds an http.Request from the parts of a Lambda event
+func newRequest(ctx context.Context, method, path, rawQuery string, header http.Header, body string, isBase64 bool) (*http.Request, error) {
+	if isBase64 {
+		b, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, fmt.Errorf("decoding base64 body: %w", err)
+		}
+		body = string(b)
+	}
+
+	// The path arrives percent-encoded, so keep it as the RawPath or it would be
+	// encoded a second time
+	decoded, err := url.PathUnescape(path)
+	if err != nil {
+		return nil, fmt.Errorf("decoding path: %w", err)
+	}
+	u := &url.URL{
+		Path:     decoded,
+		RawPath:  path,
+		RawQuery: rawQuery,
+		Host:     header.Get("Host"),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	// manually set RequestURI because NewRequest is for clients and req.RequestURI is for servers
+	req.RequestURI = u.RequestURI()
+	req.Header = header
+	req.Host = u.Host
+
+	return req, nil
+}
+
+// albQuery holds the query parameters of an ALB event as key=value pairs, in
+// the order they appear in the event
+type albQuery struct {
+	Single orderedParams `json:"queryStringParameters"`
+	Multi  orderedParams `json:"multiValueQueryStringParameters"`
+}
+
+// orderedParams is a JSON object of strings, or of lists of strings, read as
+// key=value pairs without losing the order of the keys
+type orderedParams []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (p *orderedParams) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if t, err := dec.Token(); err != nil || t == nil {
+		return err
+	}
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		k, _ := t.(string)
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+
+		var values []string
+		if err := json.Unmarshal(raw, &values); err != nil {
+			var v string
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return fmt.Errorf("query parameter %v: %w", k, err)
+			}
+			values = []string{v}
+		}
+
+		for _, v := range values {
+			*p = append(*p, k+"="+v)
+		}
+	}
+
+	return nil
+}
+
+// responseWriter collects the response for the Lambda event
+type responseWriter struct {
+	header http.Header
+	code   int
+	buf    bytes.Buffer
+}
+
+func newResponseWriter() *responseWriter {
+	return &responseWriter{header: http.Header{}}
+}
+
+// Header implements http.ResponseWriter
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+// Write implements http.ResponseWriter
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.buf.Write(b)
+}
+
+// WriteHeader implements http.ResponseWriter. Only the first status code is
+// kept, as with a real connection.
+func (w *responseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+// status returns the status code, which is 200 if the handler didn't write
+// anything
+func (w *responseWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+
+	return w.code
+}
+
+// body returns the body of the response, base64 encoding it if it isn't valid
+// text
+func (w *responseWriter) body() (string, bool) {
+	b := w.buf.Bytes()
+	if utf8.Valid(b) {
+		return string(b), false
+	}
+
+	return base64.StdEncoding.EncodeToString(b), true
+}
+
+// singleValueHeaders flattens the response headers, joining repeated headers with
+// a comma
+func singleValueHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		out[k] = strings.Join(v, ",")
+	}
+
+	return out
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// echoHandler writes the interesting parts of the request into the response
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("X-Echo", "yes")
+	w.Header().Set("X-Remote-Addr", req.RemoteAddr)
+	w.Header().Add("Set-Cookie", "a=1")
+	w.WriteHeader(http.StatusTeapot)
+	w.Write([]byte(req.Method + " " + req.Host + " " + req.URL.RequestURI()))
+})
+
+const albEvent = `{
+  "requestContext": {
+    "elb": {
+      "targetGroupArn": "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/acme/abc"
+    }
+  },
+  "httpMethod": "GET",
+  "path": "/.well-known/acme-challenge/token",
+  "queryStringParameters": {"a": "b%20c"},
+  "headers": {
+    "host": "www.alb.com",
+    "x-forwarded-for": "203.0.113.9, 192.0.2.1"
+  },
+  "body": "",
+  "isBase64Encoded": false
+}`
+
+const albMultiValueEvent = `{
+  "requestContext": {
+    "elb": {
+      "targetGroupArn": "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/acme/abc"
+    }
+  },
+  "httpMethod": "GET",
+  "path": "/hc",
+  "multiValueQueryStringParameters": {"z": ["1", "2"], "a": ["3"]},
+  "multiValueHeaders": {
+    "host": ["www.alb.com"]
+  },
+  "body": "",
+  "isBase64Encoded": false
+}`
+
+const functionURLEvent = `{
+  "version": "2.0",
+  "routeKey": "$default",
+  "rawPath": "/.well-known/acme-challenge/token",
+  "rawQueryString": "a=b",
+  "cookies": ["c=d"],
+  "headers": {
+    "host": "www.functionurl.com"
+  },
+  "requestContext": {
+    "domainName": "abc.lambda-url.us-east-1.on.aws",
+    "http": {
+      "method": "GET",
+      "path": "/.well-known/acme-challenge/token",
+      "sourceIp": "192.0.2.1"
+    },
+    "requestId": "id"
+  },
+  "isBase64Encoded": false
+}`
+
+const restEvent = `{
+  "resource": "/{proxy+}",
+  "path": "/.well-known/acme-challenge/token",
+  "httpMethod": "GET",
+  "headers": {
+    "Host": "www.rest.com"
+  },
+  "requestContext": {
+    "requestId": "id",
+    "stage": "prod"
+  },
+  "body": "",
+  "isBase64Encoded": false
+}`
+
+func TestMultiGateway_alb(t *testing.T) {
+	out, err := newMultiGateway(echoHandler).Invoke(context.Background(), []byte(albEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp events.ALBTargetGroupResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectIntMatch(t, http.StatusTeapot, resp.StatusCode)
+	helpers.ExpectStringMatch(t, "418 I'm a teapot", resp.StatusDescription)
+	helpers.ExpectStringMatch(t, "GET www.alb.com /.well-known/acme-challenge/token?a=b%20c", resp.Body)
+	helpers.ExpectStringMatch(t, "yes", resp.Headers["X-Echo"])
+	// The client can prepend to X-Forwarded-For, so we take the ALB's entry
+	helpers.ExpectStringMatch(t, "192.0.2.1:0", resp.Headers["X-Remote-Addr"])
+	if resp.MultiValueHeaders != nil {
+		t.Errorf("Expected single value headers only, got %v", resp.MultiValueHeaders)
+	}
+}
+
+func TestMultiGateway_albMultiValue(t *testing.T) {
+	out, err := newMultiGateway(echoHandler).Invoke(context.Background(), []byte(albMultiValueEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp events.ALBTargetGroupResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// The parameters keep the order they arrived in
+	helpers.ExpectStringMatch(t, "GET www.alb.com /hc?z=1&z=2&a=3", resp.Body)
+	helpers.ExpectStringMatch(t, "yes", resp.MultiValueHeaders["X-Echo"][0])
+}
+
+func TestMultiGateway_functionURL(t *testing.T) {
+	out, err := newMultiGateway(echoHandler).Invoke(context.Background(), []byte(functionURLEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp events.APIGatewayV2HTTPResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectIntMatch(t, http.StatusTeapot, resp.StatusCode)
+	helpers.ExpectStringMatch(t, "GET www.functionurl.com /.well-known/acme-challenge/token?a=b", resp.Body)
+	helpers.ExpectStringMatch(t, "yes", resp.Headers["X-Echo"])
+	helpers.ExpectStringMatch(t, "192.0.2.1:0", resp.Headers["X-Remote-Addr"])
+	if len(resp.Cookies) != 1 || resp.Cookies[0] != "a=1" {
+		t.Errorf("Expected cookie a=1, got %v", resp.Cookies)
+	}
+}
+
+func TestMultiGateway_encodedPath(t *testing.T) {
+	tests := map[string]string{
+		"alb": strings.NewReplacer(
+			`"path": "/.well-known/acme-challenge/token"`, `"path": "/a%20b/c%2Fd"`,
+			`{"a": "b%20c"}`, `{"q": "x%26y"}`,
+		).Replace(albEvent),
+		"function url": strings.NewReplacer(
+			`"rawPath": "/.well-known/acme-challenge/token"`, `"rawPath": "/a%20b/c%2Fd"`,
+			`"rawQueryString": "a=b"`, `"rawQueryString": "q=x%26y"`,
+		).Replace(functionURLEvent),
+	}
+
+	for name, event := range tests {
+		t.Run(name, func(t *testing.T) {
+			out, err := newMultiGateway(echoHandler).Invoke(context.Background(), []byte(event))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var resp struct{ Body string }
+			if err := json.Unmarshal(out, &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			// The path and query reach the handler encoded exactly once
+			helpers.ExpectStringMatch(t, "/a%20b/c%2Fd?q=x%26y", strings.Fields(resp.Body)[2])
+		})
+	}
+}
+
+func TestMultiGateway_rest(t *testing.T) {
+	out, err := newMultiGateway(echoHandler).Invoke(context.Background(), []byte(restEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp events.APIGatewayProxyResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectIntMatch(t, http.StatusTeapot, resp.StatusCode)
+	helpers.ExpectStringMatch(t, "GET www.rest.com /.well-known/acme-challenge/token", resp.Body)
+}
+
+func TestMultiGateway_implicitStatus(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	out, err := newMultiGateway(h).Invoke(context.Background(), []byte(functionURLEvent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp events.APIGatewayV2HTTPResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writing the body sends a 200, and the status can't change after that
+	helpers.ExpectIntMatch(t, http.StatusOK, resp.StatusCode)
+	helpers.ExpectStringMatch(t, "ok", resp.Body)
+}
@@ -1,52 +1,42 @@
 package main
 
 import (
-	"fmt"
 	"log"
-	"net/http"
-	"os"
 
-	"github.com/apex/gateway"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/gin-gonic/gin"
+
+	"github.com/sjauld/acme-sls/server/internal/app"
 	solver "github.com/sjauld/acme-sls/solver/http"
 )
 
-var store *solver.DynamoDBStore
-
-func init() {
-	table, ok := os.LookupEnv("DYNAMODB_TABLE_NAME")
-	if !ok {
-		log.Fatal("Please specify a DYNAMODB_TABLE_NAME env")
-	}
-
-	c := dynamodb.New(session.Must(session.NewSession()))
-
-	// Setup the DynamoDB store
-	store = solver.NewDynamoDBStore(c, table)
-}
-
 // based on https://github.com/appleboy/gin-lambda
-func routerEngine() *gin.Engine {
+func routerEngine(c *app.Config, store solver.Store) (*gin.Engine, error) {
 	// set server mode for production
 	gin.SetMode(gin.ReleaseMode)
 
-	r := gin.New()
+	return app.Router(c, store, solver.ExpvarMetrics{})
+}
 
-	// Add middleware
-	r.Use(gin.Logger())
-	r.Use(gin.Recovery())
+func main() {
+	c, err := app.ConfigFromEnv()
+	if err == nil {
+		err = c.Validate()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	r.GET("/hc", func(c *gin.Context) {
-		c.String(http.StatusOK, "OK")
-	})
-	r.GET("/.well-known/acme-challenge/:token", solver.NewGinHandlerFunc(store))
+	store, err := app.NewStore(c)
+	if err != nil {
+		log.Fatalf("error setting up the store: %v", err)
+	}
 
-	return r
-}
+	r, err := routerEngine(c, store)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-func main() {
-	addr := fmt.Sprintf(":%s", os.Getenv("PORT"))
-	log.Fatal(gateway.ListenAndServe(addr, routerEngine()))
+	// API Gateway, ALB and Function URL events are all supported
+	lambda.StartHandler(newMultiGateway(r))
 }
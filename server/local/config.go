@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sjauld/acme-sls/server/internal/app"
+)
+
+// config holds the settings of the local server. Every setting can be given
+// as a flag, or as an environment variable which provides the flag's default.
+type config struct {
+	app.Config
+
+	listenAddr  string
+	metricsAddr string
+
+	tlsCertFile string
+	tlsKeyFile  string
+
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
+}
+
+// parseConfig parses the command line arguments, falling back to the
+// environment and then to defaults that suit the docker-compose demonstration
+func parseConfig(args []string) (*config, error) {
+	ac, err := app.ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	c := &config{Config: *ac}
+	fs := flag.NewFlagSet("local", flag.ContinueOnError)
+
+	fs.StringVar(&c.listenAddr, "listen", envString("LISTEN_ADDR", ":5002"), "address to listen on")
+	fs.StringVar(&c.metricsAddr, "metrics-listen", os.Getenv("METRICS_LISTEN_ADDR"), "address to serve Prometheus metrics on; off by default")
+
+	fs.StringVar(&c.Store, "store", c.Store, "challenge store backend: dynamodb, s3 or redis")
+	fs.StringVar(&c.DynamoDBTable, "dynamodb-table", envString("DYNAMODB_TABLE_NAME", "challenges"), "DynamoDB table name")
+	fs.StringVar(&c.DynamoDBEndpoint, "dynamodb-endpoint", c.DynamoDBEndpoint, "DynamoDB endpoint, e.g. for DynamoDB local")
+	fs.StringVar(&c.Region, "region", c.Region, "AWS region")
+	fs.StringVar(&c.S3Bucket, "s3-bucket", c.S3Bucket, "S3 bucket name")
+	fs.StringVar(&c.S3KeyPrefix, "s3-key-prefix", c.S3KeyPrefix, "S3 key prefix")
+	fs.StringVar(&c.RedisAddr, "redis-addr", c.RedisAddr, "Redis address (host:port)")
+
+	fs.DurationVar(&c.HealthCheckInterval, "health-check-interval", c.HealthCheckInterval, "how long /ready caches the result of a store round trip")
+	fs.Float64Var(&c.RateLimit, "rate-limit", c.RateLimit, "challenge requests per second allowed from each client IP")
+	fs.IntVar(&c.RateLimitBurst, "rate-limit-burst", c.RateLimitBurst, "burst of challenge requests allowed from each client IP")
+	trustedProxies := fs.String("trusted-proxies", strings.Join(c.TrustedProxies, ","), "comma separated proxy addresses or CIDRs whose X-Forwarded-For identifies the client; by default none")
+	fs.DurationVar(&c.NegativeCacheTTL, "negative-cache-ttl", c.NegativeCacheTTL, "how long a token that isn't in the store is remembered")
+	fs.StringVar(&c.UpstreamURL, "upstream-url", c.UpstreamURL, "responder to forward challenge misses to")
+	fs.DurationVar(&c.UpstreamTimeout, "upstream-timeout", c.UpstreamTimeout, "timeout for requests to the upstream responder")
+
+	fs.BoolVar(&c.RedirectToHTTPS, "redirect-to-https", c.RedirectToHTTPS, "redirect everything other than the challenge and health check routes to https")
+	fs.IntVar(&c.RedirectStatusCode, "redirect-status-code", c.RedirectStatusCode, "status code of the https redirect")
+	redirectHosts := fs.String("redirect-allowed-hosts", strings.Join(c.RedirectAllowedHosts, ","), "comma separated hosts that may be redirected; by default any")
+
+	fs.StringVar(&c.tlsCertFile, "tls-cert", os.Getenv("TLS_CERT_FILE"), "TLS certificate file; serves HTTPS when set with -tls-key")
+	fs.StringVar(&c.tlsKeyFile, "tls-key", os.Getenv("TLS_KEY_FILE"), "TLS private key file")
+
+	if c.readTimeout, err = app.EnvDuration("READ_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if c.writeTimeout, err = app.EnvDuration("WRITE_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if c.idleTimeout, err = app.EnvDuration("IDLE_TIMEOUT", time.Minute); err != nil {
+		return nil, err
+	}
+	if c.shutdownTimeout, err = app.EnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+
+	fs.DurationVar(&c.readTimeout, "read-timeout", c.readTimeout, "maximum duration for reading a request")
+	fs.DurationVar(&c.writeTimeout, "write-timeout", c.writeTimeout, "maximum duration for writing a response")
+	fs.DurationVar(&c.idleTimeout, "idle-timeout", c.idleTimeout, "maximum time to keep an idle connection open")
+	fs.DurationVar(&c.shutdownTimeout, "shutdown-timeout", c.shutdownTimeout, "maximum time to drain in-flight requests on shutdown")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c.RedirectAllowedHosts = nil
+	if *redirectHosts != "" {
+		c.RedirectAllowedHosts = strings.Split(*redirectHosts, ",")
+	}
+	c.TrustedProxies = nil
+	if *trustedProxies != "" {
+		c.TrustedProxies = strings.Split(*trustedProxies, ",")
+	}
+
+	return c, c.validate()
+}
+
+// validate checks that the settings are consistent
+func (c *config) validate() error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	if (c.tlsCertFile == "") != (c.tlsKeyFile == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+
+	if c.metricsAddr != "" && c.metricsAddr == c.listenAddr {
+		return fmt.Errorf("-metrics-listen must be a different address to -listen")
+	}
+
+	return nil
+}
+
+// tls reports whether the server should serve HTTPS
+func (c *config) tls() bool {
+	return c.tlsCertFile != ""
+}
+
+// envString returns the value of an environment variable, or def if it is unset
+func envString(key, def string) string {
+	if s, ok := os.LookupEnv(key); ok {
+		return s
+	}
+
+	return def
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sjauld/acme-sls/helpers"
+	"github.com/sjauld/acme-sls/server/internal/app"
+)
+
+func TestParseConfig_defaults(t *testing.T) {
+	t.Setenv("LISTEN_ADDR", ":8080")
+	t.Setenv("SHUTDOWN_TIMEOUT", "5s")
+
+	c, err := parseConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, ":8080", c.listenAddr)
+	helpers.ExpectStringMatch(t, app.StoreDynamoDB, c.Store)
+	helpers.ExpectStringMatch(t, "challenges", c.DynamoDBTable)
+	if c.shutdownTimeout != 5*time.Second {
+		t.Errorf("Expected %v, got %v", 5*time.Second, c.shutdownTimeout)
+	}
+	if c.tls() {
+		t.Errorf("TLS should be off by default")
+	}
+	helpers.ExpectStringMatch(t, "", c.metricsAddr)
+}
+
+func TestParseConfig_flags(t *testing.T) {
+	t.Setenv("LISTEN_ADDR", ":8080")
+
+	c, err := parseConfig([]string{"-listen", ":8443", "-store", "redis", "-redis-addr", "localhost:6379", "-tls-cert", "cert.pem", "-tls-key", "key.pem", "-read-timeout", "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, ":8443", c.listenAddr)
+	helpers.ExpectStringMatch(t, app.StoreRedis, c.Store)
+	if !c.tls() {
+		t.Errorf("TLS should be on")
+	}
+	if c.readTimeout != time.Second {
+		t.Errorf("Expected %v, got %v", time.Second, c.readTimeout)
+	}
+}
+
+func TestParseConfig_challengeFlags(t *testing.T) {
+	t.Setenv("RATE_LIMIT_BURST", "50")
+	t.Setenv("REDIRECT_ALLOWED_HOSTS", "example.com")
+
+	c, err := parseConfig([]string{
+		"-health-check-interval", "1m",
+		"-rate-limit", "2.5",
+		"-negative-cache-ttl", "0",
+		"-upstream-url", "http://upstream:5002",
+		"-redirect-to-https",
+		"-redirect-allowed-hosts", "example.com,www.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment still provides the defaults
+	helpers.ExpectIntMatch(t, 50, c.RateLimitBurst)
+
+	if c.HealthCheckInterval != time.Minute {
+		t.Errorf("Expected %v, got %v", time.Minute, c.HealthCheckInterval)
+	}
+	if c.RateLimit != 2.5 {
+		t.Errorf("Expected 2.5, got %v", c.RateLimit)
+	}
+	if c.NegativeCacheTTL != 0 {
+		t.Errorf("Expected the negative cache to be off, got %v", c.NegativeCacheTTL)
+	}
+	helpers.ExpectStringMatch(t, "http://upstream:5002", c.UpstreamURL)
+	if !c.RedirectToHTTPS {
+		t.Errorf("Expected redirects to be on")
+	}
+	helpers.ExpectIntMatch(t, 2, len(c.RedirectAllowedHosts))
+}
+
+func TestParseConfig_s3Bucket(t *testing.T) {
+	t.Setenv("S3_BUCKET_NAME", "bucket")
+
+	c, err := parseConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, app.StoreS3, c.Store)
+	helpers.ExpectStringMatch(t, "bucket", c.S3Bucket)
+}
+
+func TestParseConfig_invalid(t *testing.T) {
+	tests := [][]string{
+		{"-store", "mysql"},
+		{"-store", "redis"},
+		{"-store", "s3"},
+		{"-tls-cert", "cert.pem"},
+		{"-read-timeout", "soon"},
+		{"-rate-limit-burst", "lots"},
+		{"-listen", ":9090", "-metrics-listen", ":9090"},
+	}
+
+	for _, args := range tests {
+		if _, err := parseConfig(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+
+	t.Setenv("WRITE_TIMEOUT", "soon")
+	if _, err := parseConfig(nil); err == nil {
+		t.Errorf("Expected an error for an invalid WRITE_TIMEOUT")
+	}
+
+	t.Setenv("WRITE_TIMEOUT", "1s")
+	t.Setenv("HEALTH_CHECK_INTERVAL", "soon")
+	if _, err := parseConfig(nil); err == nil {
+		t.Errorf("Expected an error for an invalid HEALTH_CHECK_INTERVAL")
+	}
+}
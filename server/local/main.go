@@ -1,46 +1,114 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
-	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/sjauld/acme-sls/server/internal/app"
 	solver "github.com/sjauld/acme-sls/solver/http"
+	"github.com/sjauld/acme-sls/solver/http/prommetrics"
 )
 
-func testDynamoDBClient() dynamodbiface.DynamoDBAPI {
-	os.Setenv("AWS_ACCESS_KEY_ID", "AKIABLAHBLAH")
-	os.Setenv("AWS_SECRET_ACCESS_KEY", "12345")
+// metricsServer returns a server for /metrics on its own address, so that the
+// metrics aren't exposed next to the challenges
+func metricsServer(c *config) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
 
-	config := &aws.Config{
-		Region:   aws.String("ap-southeast-2"),
-		Endpoint: aws.String("http://dynamodb:8000"),
+	return &http.Server{
+		Addr:         c.metricsAddr,
+		Handler:      mux,
+		ReadTimeout:  c.readTimeout,
+		WriteTimeout: c.writeTimeout,
+		IdleTimeout:  c.idleTimeout,
+	}
+}
+
+// serve runs the servers until ctx is cancelled, then waits up to the shutdown
+// timeout for in-flight requests to finish. metrics may be nil.
+func serve(ctx context.Context, c *config, srv, metrics *http.Server) error {
+	errs := make(chan error, 2)
+	go func() {
+		if c.tls() {
+			errs <- srv.ListenAndServeTLS(c.tlsCertFile, c.tlsKeyFile)
+		} else {
+			errs <- srv.ListenAndServe()
+		}
+	}()
+	if metrics != nil {
+		go func() {
+			errs <- metrics.ListenAndServe()
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		log.Printf("[INFO] shutting down, draining in-flight requests")
 	}
 
-	sess := session.Must(session.NewSession(config))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
+	defer cancel()
 
-	log.Printf("[INFO] dynamoDB initialising")
+	if metrics != nil {
+		metrics.Shutdown(shutdownCtx)
+	}
+	if shutdownErr := srv.Shutdown(shutdownCtx); err == nil {
+		err = shutdownErr
+	}
 
-	return dynamodb.New(sess)
+	return err
 }
 
 func main() {
-	r := gin.Default()
+	c, err := parseConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	r.GET("/hc", func(c *gin.Context) {
-		c.String(http.StatusOK, "OK")
-	})
+	store, err := app.NewStore(&c.Config)
+	if err != nil {
+		log.Fatalf("error setting up the store: %v", err)
+	}
+
+	// Challenge traffic and Store latency are exposed at /metrics on the
+	// metrics address, if there is one
+	var metrics solver.Metrics = solver.ExpvarMetrics{}
+	var msrv *http.Server
+	if c.metricsAddr != "" {
+		metrics = prommetrics.New(prometheus.DefaultRegisterer)
+		msrv = metricsServer(c)
+		log.Printf("[INFO] serving metrics on %v", c.metricsAddr)
+	}
+	store = solver.InstrumentStore(store, metrics)
+
+	r, err := app.Router(&c.Config, store, metrics)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:         c.listenAddr,
+		Handler:      r,
+		ReadTimeout:  c.readTimeout,
+		WriteTimeout: c.writeTimeout,
+		IdleTimeout:  c.idleTimeout,
+	}
 
-	r.GET("/.well-known/acme-challenge/:token", solver.NewGinHandlerFunc(solver.NewDynamoDBStore(testDynamoDBClient(), "challenges")))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// listen and serve on 0.0.0.0:5002
-	if err := r.Run(":5002"); err != nil {
-		log.Printf("error starting server %+v", err)
+	log.Printf("[INFO] listening on %v", c.listenAddr)
+	if err := serve(ctx, c, srv, msrv); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("error running server: %v", err)
 	}
 }
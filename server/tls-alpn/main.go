@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/sjauld/acme-sls/server/internal/app"
+	solver "github.com/sjauld/acme-sls/solver/http"
+	tlsalpn "github.com/sjauld/acme-sls/solver/tls-alpn"
+)
+
+// newResponder returns a Responder for the store, passing normal TLS traffic
+// through to BACKEND_ADDR if it is set
+func newResponder(store solver.Store) *tlsalpn.Responder {
+	r := tlsalpn.NewResponder(store)
+
+	if addr, ok := os.LookupEnv("BACKEND_ADDR"); ok {
+		r.WithBackend(addr)
+	} else {
+		log.Printf("[INFO] no BACKEND_ADDR, connections that aren't for acme-tls/1 will be closed")
+	}
+
+	if s, ok := os.LookupEnv("HANDSHAKE_TIMEOUT"); ok {
+		timeout, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("Invalid HANDSHAKE_TIMEOUT: %v", err)
+		}
+		r.WithHandshakeTimeout(timeout)
+	}
+
+	return r
+}
+
+func main() {
+	c, err := app.ConfigFromEnv()
+	if err == nil {
+		err = c.Validate()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := app.NewStore(c)
+	if err != nil {
+		log.Fatalf("error setting up the store: %v", err)
+	}
+
+	addr := ":443"
+	if s, ok := os.LookupEnv("LISTEN_ADDR"); ok {
+		addr = s
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("error listening on %v: %v", addr, err)
+	}
+
+	log.Printf("[INFO] answering acme-tls/1 handshakes on %v", addr)
+
+	if err := newResponder(store).Serve(ln); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sjauld/acme-sls/helpers"
+	solver "github.com/sjauld/acme-sls/solver/http"
+	tlsalpn "github.com/sjauld/acme-sls/solver/tls-alpn"
+)
+
+// serve runs the Responder from the environment on a local listener, with a
+// challenge for www.example.com in its store, returning its address
+func serve(t *testing.T) string {
+	mr := miniredis.RunT(t)
+	c := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { c.Close() })
+	store := solver.NewRedisStore(c)
+
+	if err := tlsalpn.New(store).Present("www.example.com", "token", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go newResponder(store).Serve(ln)
+
+	return ln.Addr().String()
+}
+
+func TestNewResponder_handshake(t *testing.T) {
+	t.Setenv("HANDSHAKE_TIMEOUT", "1s")
+	addr := serve(t)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         "www.example.com",
+		NextProtos:         []string{tlsalpn01.ACMETLS1Protocol},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	helpers.ExpectStringMatch(t, tlsalpn01.ACMETLS1Protocol, state.NegotiatedProtocol)
+	helpers.ExpectStringMatch(t, "www.example.com", state.PeerCertificates[0].DNSNames[0])
+}
+
+func TestNewResponder_backend(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "backend")
+	}))
+	defer backend.Close()
+
+	t.Setenv("BACKEND_ADDR", backend.Listener.Addr().String())
+	addr := serve(t)
+
+	resp, err := backend.Client().Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectStringMatch(t, "backend", string(body))
+}
@@ -0,0 +1,136 @@
+package alpn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// idPeAcmeIdentifierV1 is the OID of the challenge certificate extension that
+// holds the SHA-256 digest of the keyauth (RFC 8737)
+var idPeAcmeIdentifierV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// placeholderValidity is how long the certificate restored by CleanUp is valid
+const placeholderValidity = 365 * 24 * time.Hour
+
+// challengeCert returns a PEM encoded TLS-ALPN-01 challenge certificate and key
+// for the domain
+func challengeCert(domain, keyAuth string, keyType certcrypto.KeyType) ([]byte, []byte, error) {
+	digest := sha256.Sum256([]byte(keyAuth))
+	value, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The extension is critical so that the certificate won't be used by
+	// anything other than the CA
+	extensions := []pkix.Extension{
+		{
+			Id:       idPeAcmeIdentifierV1,
+			Critical: true,
+			Value:    value,
+		},
+	}
+
+	return selfSignedCert([]string{domain}, extensions, keyType, time.Hour)
+}
+
+// placeholderCert returns a PEM encoded self-signed certificate and key for the
+// names, used to take the place of a challenge certificate after validation
+func placeholderCert(names []string, keyType certcrypto.KeyType) ([]byte, []byte, error) {
+	return selfSignedCert(names, nil, keyType, placeholderValidity)
+}
+
+// selfSignedCert creates a key of the given type and a certificate for it
+func selfSignedCert(names []string, extensions []pkix.Extension, keyType certcrypto.KeyType, validity time.Duration) ([]byte, []byte, error) {
+	key, err := certcrypto.GeneratePrivateKey(keyType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported key type %v", keyType)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: names[0]},
+		DNSNames:              names,
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		ExtraExtensions:       extensions,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// encodePrivateKey PEM encodes a key in the form ACM accepts
+func encodePrivateKey(key crypto.PrivateKey) ([]byte, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(k),
+		}), nil
+	case *ecdsa.PrivateKey:
+		b, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: b,
+		}), nil
+	default:
+		return nil, fmt.Errorf("Expected an RSA or ECDSA key but got %T", key)
+	}
+}
+
+// keyTypeOf returns the KeyType matching a certificate's public key, so that a
+// placeholder can use the same algorithm as the certificate it replaces
+func keyTypeOf(cert *x509.Certificate) certcrypto.KeyType {
+	switch k := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if k.Curve.Params().BitSize == 384 {
+			return certcrypto.EC384
+		}
+		return certcrypto.EC256
+	case *rsa.PublicKey:
+		if k.N.BitLen() >= 4096 {
+			return certcrypto.RSA4096
+		}
+	}
+
+	return certcrypto.RSA2048
+}
@@ -1,84 +1,303 @@
 package alpn
 
 import (
-	"crypto/rsa"
+	"context"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"log"
-	"time"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/acm"
 	"github.com/aws/aws-sdk-go/service/acm/acmiface"
-	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+	"github.com/go-acme/lego/v4/certcrypto"
+	solver "github.com/sjauld/acme-sls/solver/http"
+	"github.com/sjauld/acme-sls/solver/selfcheck"
 )
 
+// ErrCertificateInUse is returned by Present when a domain's challenge
+// certificate is already holding the challenge for another domain. lego presents
+// every TLS-ALPN-01 challenge before validating any of them, so the second
+// import would overwrite the first.
+var ErrCertificateInUse = errors.New("the challenge certificate is in use by another domain")
+
 // Solver implements lego's challenge.Provider
 type Solver struct {
+	ctx       context.Context
 	acmClient acmiface.ACMAPI
-	certARN   string
+	keyType   certcrypto.KeyType
+
+	// The challenge certificate to overwrite for each domain, falling back to
+	// certARN. If neither is set, a new certificate is imported.
+	certARN  string
+	certARNs map[string]string
+	// The certificate and key to put back for each domain on CleanUp
+	originals map[string]original
+
+	// Check that the challenge certificate is being served before the CA is
+	// notified
+	selfCheck *selfcheck.Checker
+
+	// What Present did for each domain, so that CleanUp can undo it
+	mu       sync.Mutex
+	imported map[string]importedCert
+	// The domain that each challenge certificate is presenting
+	claimed map[string]string
+}
+
+// original is a challenge certificate as it was before Present, in PEM
+type original struct {
+	certPEM, keyPEM []byte
+}
+
+// importedCert records a challenge certificate imported by Present
+type importedCert struct {
+	arn string
+	// created is true if there was no challenge certificate to overwrite
+	created bool
+	// names and keyType of the certificate that was overwritten
+	names   []string
+	keyType certcrypto.KeyType
 }
 
 // New returns a pointer to a Solver, initialised with an ACM client and a target
-// certificateARN to update
+// certificateARN to update. The certificateARN is used for every domain that
+// doesn't have one set with WithCertificateARNs.
 func New(client acmiface.ACMAPI, certARN string) *Solver {
 	return &Solver{
+		ctx:       context.Background(),
 		acmClient: client,
+		keyType:   certcrypto.RSA2048,
 		certARN:   certARN,
+		certARNs:  map[string]string{},
+		originals: map[string]original{},
+		selfCheck: selfcheck.New(),
+		imported:  map[string]importedCert{},
+		claimed:   map[string]string{},
 	}
 }
 
+// WithContext sets the context used by Present and CleanUp
+func (s *Solver) WithContext(ctx context.Context) *Solver {
+	s.ctx = ctx
+	return s
+}
+
+// WithCertificateARNs sets the challenge certificate to overwrite for each
+// domain, since each API Gateway custom domain has its own certificate. Only
+// one domain at a time can use a challenge certificate, so domains in the same
+// order need their own.
+func (s *Solver) WithCertificateARNs(arns map[string]string) *Solver {
+	for domain, arn := range arns {
+		s.certARNs[solver.NormaliseDomain(domain)] = arn
+	}
+	return s
+}
+
+// WithOriginal sets the certificate and key, in PEM, that CleanUp re-imports
+// over the domain's challenge certificate. ACM doesn't let us export the key of
+// an imported certificate, so without one CleanUp imports a new self-signed
+// placeholder with the same names and key type instead.
+func (s *Solver) WithOriginal(domain string, certPEM, keyPEM []byte) *Solver {
+	s.originals[solver.NormaliseDomain(domain)] = original{certPEM: certPEM, keyPEM: keyPEM}
+	return s
+}
+
+// WithKeyType sets the type of key used for challenge certificates, e.g.
+// certcrypto.EC256. The default is certcrypto.RSA2048.
+func (s *Solver) WithKeyType(kt certcrypto.KeyType) *Solver {
+	s.keyType = kt
+	return s
+}
+
+// WithSelfCheck overrides the check that Present uses to wait until the new
+// challenge certificate is served, e.g. to change the timeout. Pass nil to
+// notify the CA as soon as the certificate is imported.
+func (s *Solver) WithSelfCheck(c *selfcheck.Checker) *Solver {
+	s.selfCheck = c
+	return s
+}
+
 // Present creates a certificate and imports it to ACM over the top of the
 // pre-existing challenge certificate.
 func (s *Solver) Present(domain, token, keyAuth string) error {
+	return s.PresentWithContext(s.ctx, domain, token, keyAuth)
+}
+
+// PresentWithContext is the same as Present with the addition of the ability
+// to pass a context
+func (s *Solver) PresentWithContext(ctx context.Context, domain, token, keyAuth string) error {
 	log.Printf("[INFO] Presenting domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
 
-	// use lego's library to create a certificate from the challenge issued by the CA
-	cert, err := tlsalpn01.ChallengeCert(domain, keyAuth)
+	imported := importedCert{
+		arn:     s.arnFor(domain),
+		names:   []string{domain},
+		keyType: s.keyType,
+	}
+
+	if err := s.claim(imported.arn, domain); err != nil {
+		return err
+	}
+
+	// Remember what the original certificate looked like so that CleanUp can
+	// put something equivalent back
+	if imported.arn != "" {
+		original, err := s.getCertificate(ctx, imported.arn)
+		if err != nil {
+			s.release(imported.arn, domain)
+			return err
+		}
+		if len(original.DNSNames) > 0 {
+			imported.names = original.DNSNames
+		}
+		imported.keyType = keyTypeOf(original)
+	}
+
+	certPEM, keyPEM, err := challengeCert(domain, keyAuth, s.keyType)
 	if err != nil {
+		s.release(imported.arn, domain)
 		return err
 	}
 
-	// tlsalpn01 creates an RSA2048 private key
-	privateKey, ok := cert.PrivateKey.(*rsa.PrivateKey)
-	if !ok {
-		return fmt.Errorf("Expected an RSA key but got %v", cert.PrivateKey)
+	arn, err := s.importCertificate(ctx, imported.arn, certPEM, keyPEM)
+	if err != nil {
+		s.release(imported.arn, domain)
+		return err
 	}
+	imported.created = imported.arn == ""
+	imported.arn = arn
 
-	// Encode the certificate and key into PEM format for ACM
-	pemCert := pem.EncodeToMemory(&pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: cert.Certificate[0],
-	})
+	s.mu.Lock()
+	s.imported[solver.NormaliseDomain(domain)] = imported
+	s.mu.Unlock()
 
-	pemKey := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-	})
+	if s.selfCheck == nil {
+		log.Printf("[INFO] successfully imported challenge certificate %v", arn)
+		return nil
+	}
 
-	// import the new cert into ACM
-	req := &acm.ImportCertificateInput{
-		Certificate: pemCert,
-		PrivateKey:  pemKey,
+	log.Printf("[INFO] successfully imported challenge certificate %v; waiting for it to be served", arn)
+	return s.selfCheck.TLSALPN01(ctx, domain, keyAuth)
+}
+
+// CleanUp restores the challenge certificate
+func (s *Solver) CleanUp(domain, token, keyAuth string) error {
+	return s.CleanUpWithContext(s.ctx, domain, token, keyAuth)
+}
+
+// CleanUpWithContext is the same as CleanUp with the addition of the ability
+// to pass a context. The original set with WithOriginal is re-imported, or a
+// placeholder if there isn't one. A certificate that Present created is deleted
+// instead.
+func (s *Solver) CleanUpWithContext(ctx context.Context, domain, token, keyAuth string) error {
+	log.Printf("[INFO] CleaningUp domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
+
+	s.mu.Lock()
+	imported, ok := s.imported[solver.NormaliseDomain(domain)]
+	delete(s.imported, solver.NormaliseDomain(domain))
+	s.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	defer s.release(imported.arn, domain)
+
+	if imported.created {
+		_, err := s.acmClient.DeleteCertificateWithContext(ctx, &acm.DeleteCertificateInput{
+			CertificateArn: aws.String(imported.arn),
+		})
+		if err != nil {
+			return fmt.Errorf("deleting challenge certificate %v: %w", imported.arn, err)
+		}
+		return nil
 	}
-	if s.certARN != "" {
-		req.CertificateArn = aws.String(s.certARN)
+
+	if o, ok := s.originals[solver.NormaliseDomain(domain)]; ok {
+		_, err := s.importCertificate(ctx, imported.arn, o.certPEM, o.keyPEM)
+		return err
 	}
-	_, err = s.acmClient.ImportCertificate(req)
+
+	log.Printf("[INFO] no original for %v, importing a placeholder over %v", domain, imported.arn)
+	certPEM, keyPEM, err := placeholderCert(imported.names, imported.keyType)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	log.Printf("[INFO] successfully imported certificate; having a nap so it can propogate")
-	time.Sleep(60 * time.Second)
+	_, err = s.importCertificate(ctx, imported.arn, certPEM, keyPEM)
+	return err
+}
+
+// arnFor returns the challenge certificate to overwrite for a domain
+func (s *Solver) arnFor(domain string) string {
+	if arn, ok := s.certARNs[solver.NormaliseDomain(domain)]; ok {
+		return arn
+	}
 
-	return nil
+	return s.certARN
 }
 
-// CleanUp is a no-op
-func (s *Solver) CleanUp(domain, token, keyAuth string) error {
-	log.Printf("[INFO] CleaningUp domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
+// claim reserves the challenge certificate arn for domain until CleanUp. New
+// certificates (an empty arn) are never shared.
+func (s *Solver) claim(arn, domain string) error {
+	if arn == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if other, ok := s.claimed[arn]; ok && other != solver.NormaliseDomain(domain) {
+		return fmt.Errorf("%v can't use %v while %v is pending: %w", domain, arn, other, ErrCertificateInUse)
+	}
+	s.claimed[arn] = solver.NormaliseDomain(domain)
 
 	return nil
 }
+
+// release frees a challenge certificate claimed by domain
+func (s *Solver) release(arn, domain string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.claimed[arn] == solver.NormaliseDomain(domain) {
+		delete(s.claimed, arn)
+	}
+}
+
+// getCertificate fetches and parses a certificate from ACM
+func (s *Solver) getCertificate(ctx context.Context, arn string) (*x509.Certificate, error) {
+	resp, err := s.acmClient.GetCertificateWithContext(ctx, &acm.GetCertificateInput{
+		CertificateArn: aws.String(arn),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting challenge certificate %v: %w", arn, err)
+	}
+
+	block, _ := pem.Decode([]byte(aws.StringValue(resp.Certificate)))
+	if block == nil {
+		return nil, errors.New("ACM returned an invalid certificate")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// importCertificate imports a certificate into ACM, over the top of arn if it
+// is set, and returns its ARN
+func (s *Solver) importCertificate(ctx context.Context, arn string, certPEM, keyPEM []byte) (string, error) {
+	req := &acm.ImportCertificateInput{
+		Certificate: certPEM,
+		PrivateKey:  keyPEM,
+	}
+	if arn != "" {
+		req.CertificateArn = aws.String(arn)
+	}
+
+	resp, err := s.acmClient.ImportCertificateWithContext(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("importing certificate %v: %w", arn, err)
+	}
+
+	return aws.StringValue(resp.CertificateArn), nil
+}
@@ -0,0 +1,264 @@
+package alpn
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/acm/acmiface"
+	"github.com/go-acme/lego/v4/certcrypto"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// fakeACM keeps imported certificates in memory, keyed by ARN
+type fakeACM struct {
+	acmiface.ACMAPI
+	certs     map[string][]byte
+	keys      map[string][]byte
+	next      int
+	importErr error
+}
+
+func newFakeACM() *fakeACM {
+	return &fakeACM{
+		certs: map[string][]byte{},
+		keys:  map[string][]byte{},
+	}
+}
+
+func (f *fakeACM) ImportCertificateWithContext(ctx aws.Context, in *acm.ImportCertificateInput, opts ...request.Option) (*acm.ImportCertificateOutput, error) {
+	if f.importErr != nil {
+		return nil, f.importErr
+	}
+
+	// The cert and key have to match, as ACM checks
+	if _, err := tls.X509KeyPair(in.Certificate, in.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	arn := aws.StringValue(in.CertificateArn)
+	if arn == "" {
+		f.next++
+		arn = fmt.Sprintf("arn:aws:acm:us-east-1:123456789012:certificate/%d", f.next)
+	}
+	f.certs[arn] = in.Certificate
+	f.keys[arn] = in.PrivateKey
+
+	return &acm.ImportCertificateOutput{CertificateArn: aws.String(arn)}, nil
+}
+
+func (f *fakeACM) GetCertificateWithContext(ctx aws.Context, in *acm.GetCertificateInput, opts ...request.Option) (*acm.GetCertificateOutput, error) {
+	cert, ok := f.certs[aws.StringValue(in.CertificateArn)]
+	if !ok {
+		return nil, errors.New("ResourceNotFoundException")
+	}
+
+	return &acm.GetCertificateOutput{Certificate: aws.String(string(cert))}, nil
+}
+
+func (f *fakeACM) DeleteCertificateWithContext(ctx aws.Context, in *acm.DeleteCertificateInput, opts ...request.Option) (*acm.DeleteCertificateOutput, error) {
+	delete(f.certs, aws.StringValue(in.CertificateArn))
+	delete(f.keys, aws.StringValue(in.CertificateArn))
+
+	return &acm.DeleteCertificateOutput{}, nil
+}
+
+// parseCert parses the certificate stored under arn
+func (f *fakeACM) parseCert(t *testing.T, arn string) *x509.Certificate {
+	block, _ := pem.Decode(f.certs[arn])
+	if block == nil {
+		t.Fatalf("No certificate for %v", arn)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// keyAuthDigest returns the acmeIdentifier digest of a certificate, if it has one
+func keyAuthDigest(t *testing.T, cert *x509.Certificate) []byte {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(idPeAcmeIdentifierV1) {
+			var digest []byte
+			if _, err := asn1.Unmarshal(ext.Value, &digest); err != nil {
+				t.Fatal(err)
+			}
+			return digest
+		}
+	}
+
+	return nil
+}
+
+// addPlaceholder imports an ECDSA placeholder for the names, as you'd do when
+// setting up the API Gateway custom domain
+func addPlaceholder(t *testing.T, f *fakeACM, names ...string) string {
+	certPEM, keyPEM, err := placeholderCert(names, certcrypto.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := f.ImportCertificateWithContext(context.Background(), &acm.ImportCertificateInput{Certificate: certPEM, PrivateKey: keyPEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return aws.StringValue(resp.CertificateArn)
+}
+
+func TestPresentCleanUp(t *testing.T) {
+	f := newFakeACM()
+	www := addPlaceholder(t, f, "www.example.com")
+	api := addPlaceholder(t, f, "api.example.com", "api2.example.com")
+
+	s := New(f, "").WithSelfCheck(nil).WithCertificateARNs(map[string]string{
+		"www.example.com": www,
+		"API.example.com": api,
+	})
+
+	for _, domain := range []string{"www.example.com", "api.example.com"} {
+		if err := s.Present(domain, "token", domain+".keyauth"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each domain's certificate was overwritten with its own challenge
+	for arn, domain := range map[string]string{www: "www.example.com", api: "api.example.com"} {
+		cert := f.parseCert(t, arn)
+		helpers.ExpectStringMatch(t, domain, cert.DNSNames[0])
+
+		expected := sha256.Sum256([]byte(domain + ".keyauth"))
+		if !bytes.Equal(expected[:], keyAuthDigest(t, cert)) {
+			t.Errorf("Challenge certificate for %v has the wrong digest", domain)
+		}
+	}
+	helpers.ExpectIntMatch(t, 2, len(f.certs))
+
+	for _, domain := range []string{"www.example.com", "api.example.com"} {
+		if err := s.CleanUp(domain, "token", domain+".keyauth"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The placeholders are back, with the original names and key type
+	cert := f.parseCert(t, api)
+	if keyAuthDigest(t, cert) != nil {
+		t.Errorf("CleanUp left the challenge certificate in place")
+	}
+	helpers.ExpectIntMatch(t, 2, len(cert.DNSNames))
+	helpers.ExpectStringMatch(t, "api2.example.com", cert.DNSNames[1])
+	if _, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok {
+		t.Errorf("Expected an ECDSA placeholder, got %T", cert.PublicKey)
+	}
+}
+
+func TestCleanUp_original(t *testing.T) {
+	f := newFakeACM()
+	certPEM, keyPEM, err := placeholderCert([]string{"www.example.com"}, certcrypto.EC256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := f.ImportCertificateWithContext(context.Background(), &acm.ImportCertificateInput{Certificate: certPEM, PrivateKey: keyPEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	arn := aws.StringValue(resp.CertificateArn)
+
+	s := New(f, arn).WithSelfCheck(nil).WithOriginal("WWW.example.com", certPEM, keyPEM)
+	if err := s.Present("www.example.com", "token", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CleanUp("www.example.com", "token", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The original certificate and key are back, rather than a placeholder
+	if !bytes.Equal(certPEM, f.certs[arn]) || !bytes.Equal(keyPEM, f.keys[arn]) {
+		t.Errorf("Expected CleanUp to re-import the original certificate")
+	}
+}
+
+func TestPresentCleanUp_newCertificate(t *testing.T) {
+	f := newFakeACM()
+	s := New(f, "").WithSelfCheck(nil)
+
+	if err := s.Present("www.example.com", "token", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 1, len(f.certs))
+
+	// The certificate we created is removed again
+	if err := s.CleanUp("www.example.com", "token", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 0, len(f.certs))
+}
+
+func TestPresent_ecdsa(t *testing.T) {
+	f := newFakeACM()
+	arn := addPlaceholder(t, f, "www.example.com")
+	s := New(f, arn).WithSelfCheck(nil).WithKeyType(certcrypto.EC256)
+
+	if err := s.Present("www.example.com", "token", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(f.keys[arn])
+	helpers.ExpectStringMatch(t, "EC PRIVATE KEY", block.Type)
+}
+
+func TestPresent_importError(t *testing.T) {
+	f := newFakeACM()
+	f.importErr = errors.New("LimitExceededException")
+	s := New(f, "").WithSelfCheck(nil)
+
+	// The error is returned rather than killing the process
+	if err := s.Present("www.example.com", "token", "keyauth"); err == nil {
+		t.Errorf("Expected an error when the import fails")
+	}
+}
+
+func TestPresent_sharedCertificate(t *testing.T) {
+	f := newFakeACM()
+	arn := addPlaceholder(t, f, "placeholder.example.com")
+	s := New(f, arn).WithSelfCheck(nil)
+
+	if err := s.Present("www.example.com", "token", "www.keyauth"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second domain would overwrite the first domain's challenge
+	err := s.Present("api.example.com", "token", "api.keyauth")
+	if !errors.Is(err, ErrCertificateInUse) {
+		t.Errorf("Expected ErrCertificateInUse, got %v", err)
+	}
+	expected := sha256.Sum256([]byte("www.keyauth"))
+	if !bytes.Equal(expected[:], keyAuthDigest(t, f.parseCert(t, arn))) {
+		t.Errorf("The first domain's challenge certificate was overwritten")
+	}
+
+	// Once the first domain is cleaned up the certificate can be used again,
+	// and the original names are restored
+	if err := s.CleanUp("www.example.com", "token", "www.keyauth"); err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectStringMatch(t, "placeholder.example.com", f.parseCert(t, arn).DNSNames[0])
+	if err := s.Present("api.example.com", "token", "api.keyauth"); err != nil {
+		t.Fatal(err)
+	}
+}
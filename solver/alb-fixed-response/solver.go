@@ -0,0 +1,464 @@
+// package alb solves the ACMEv2 HTTP-01 challenge with an Application Load
+// Balancer. The workflow is as follows:
+//
+// 1. client requests a certificate from the remote CA, using the Solver as the HTTP-01 challenge
+// 2. Solver adds a rule to the port 80 listener that matches the host and challenge path, with a fixed response of the keyauth
+// 3. remote CA requests the keyauth from the well known path on the load balancer
+// 4. the load balancer answers with the fixed response, and the Solver deletes the rule on CleanUp
+//
+// Nothing behind the load balancer needs to know about the challenge.
+package alb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+)
+
+const (
+	// DefaultFirstPriority is the lowest priority a challenge rule can have.
+	// Lower numbers are evaluated first, so a challenge rule has to come
+	// before every existing rule from here on to win over them.
+	DefaultFirstPriority = 1
+	// DefaultMaxRules is the default quota for rules on an Application Load
+	// Balancer, not counting default rules
+	DefaultMaxRules = 100
+
+	// maxPriority is the highest priority a rule can have
+	maxPriority = 50000
+	// maxAttempts is how many times we try to create a rule when another
+	// writer takes the priority we picked
+	maxAttempts = 5
+)
+
+var (
+	// ErrRuleLimit is returned when the load balancer has no room for another
+	// rule
+	ErrRuleLimit = errors.New("the load balancer has reached its rule limit")
+	// ErrNoPriority is returned when there's no priority ahead of the
+	// listener's existing rules, and they can't be moved to make one
+	ErrNoPriority = errors.New("no free priority ahead of the existing rules")
+)
+
+// challengePath is the path the CA requests the keyauth from
+const challengePath = "/.well-known/acme-challenge/"
+
+// Solver implements lego's challenge.Provider
+type Solver struct {
+	ctx           context.Context
+	client        elbv2iface.ELBV2API
+	listenerARN   string
+	firstPriority int
+	maxRules      int
+
+	// The rule created for each token, so that CleanUp can delete it, and the
+	// original priority of each rule moved to make room for them
+	mu    sync.Mutex
+	rules map[string]string
+	moved map[string]int
+}
+
+// New returns a pointer to a Solver, initialised with an ELBv2 client and the
+// ARN of the listener that the CA will connect to (normally port 80)
+func New(client elbv2iface.ELBV2API, listenerARN string) *Solver {
+	return &Solver{
+		ctx:           context.Background(),
+		client:        client,
+		listenerARN:   listenerARN,
+		firstPriority: DefaultFirstPriority,
+		maxRules:      DefaultMaxRules,
+		rules:         map[string]string{},
+		moved:         map[string]int{},
+	}
+}
+
+// WithContext sets the context used by Present and CleanUp
+func (s *Solver) WithContext(ctx context.Context) *Solver {
+	s.ctx = ctx
+	return s
+}
+
+// WithFirstPriority allows you to override DefaultFirstPriority, e.g. to keep
+// the rules with lower priorities ahead of the challenges
+func (s *Solver) WithFirstPriority(p int) *Solver {
+	s.firstPriority = p
+	return s
+}
+
+// WithMaxRules allows you to override DefaultMaxRules. The rules on every
+// listener of the load balancer count towards it.
+func (s *Solver) WithMaxRules(n int) *Solver {
+	s.maxRules = n
+	return s
+}
+
+// Present adds a listener rule that answers the challenge with a fixed response
+func (s *Solver) Present(domain, token, keyAuth string) error {
+	return s.PresentWithContext(s.ctx, domain, token, keyAuth)
+}
+
+// PresentWithContext is the same as Present with the addition of the ability
+// to pass a context
+func (s *Solver) PresentWithContext(ctx context.Context, domain, token, keyAuth string) error {
+	log.Printf("[INFO] Presenting domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
+
+	if err := s.checkRuleLimit(ctx); err != nil {
+		return err
+	}
+
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		var priority int
+		priority, err = s.freePriority(ctx)
+		if err != nil {
+			return err
+		}
+
+		var arn string
+		arn, err = s.createRule(ctx, domain, token, keyAuth, priority)
+		if err == nil {
+			log.Printf("[INFO] created rule %v with priority %d", arn, priority)
+			s.mu.Lock()
+			s.rules[token] = arn
+			s.mu.Unlock()
+			return nil
+		}
+
+		aerr, ok := err.(awserr.Error)
+		if !ok || aerr.Code() != elbv2.ErrCodePriorityInUseException {
+			break
+		}
+
+		log.Printf("[DEBUG] priority %d was taken, retrying", priority)
+	}
+
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == elbv2.ErrCodeTooManyRulesException {
+		return fmt.Errorf("%w: %v", ErrRuleLimit, err)
+	}
+
+	return fmt.Errorf("creating challenge rule on %v: %w", s.listenerARN, err)
+}
+
+// CleanUp deletes the challenge rule, and puts back any rules that were moved
+// to make room for the challenges
+func (s *Solver) CleanUp(domain, token, keyAuth string) error {
+	return s.CleanUpWithContext(s.ctx, domain, token, keyAuth)
+}
+
+// CleanUpWithContext is the same as CleanUp with the addition of the ability
+// to pass a context
+func (s *Solver) CleanUpWithContext(ctx context.Context, domain, token, keyAuth string) error {
+	log.Printf("[INFO] CleaningUp domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
+
+	s.mu.Lock()
+	arn, ok := s.rules[token]
+	delete(s.rules, token)
+	s.mu.Unlock()
+
+	// If the rule was created by someone else, e.g. a previous invocation, find
+	// it by its path
+	if !ok {
+		var err error
+		arn, err = s.findRule(ctx, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	if arn != "" {
+		_, err := s.client.DeleteRuleWithContext(ctx, &elbv2.DeleteRuleInput{
+			RuleArn: aws.String(arn),
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == elbv2.ErrCodeRuleNotFoundException {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.restorePriorities(ctx)
+}
+
+// createRule adds the challenge rule to the listener and returns its ARN
+func (s *Solver) createRule(ctx context.Context, domain, token, keyAuth string, priority int) (string, error) {
+	resp, err := s.client.CreateRuleWithContext(ctx, &elbv2.CreateRuleInput{
+		ListenerArn: aws.String(s.listenerARN),
+		Priority:    aws.Int64(int64(priority)),
+		Conditions: []*elbv2.RuleCondition{
+			{
+				Field: aws.String("host-header"),
+				HostHeaderConfig: &elbv2.HostHeaderConditionConfig{
+					Values: []*string{aws.String(domain)},
+				},
+			},
+			{
+				Field: aws.String("path-pattern"),
+				PathPatternConfig: &elbv2.PathPatternConditionConfig{
+					Values: []*string{aws.String(challengePath + token)},
+				},
+			},
+		},
+		Actions: []*elbv2.Action{
+			{
+				Type: aws.String(elbv2.ActionTypeEnumFixedResponse),
+				FixedResponseConfig: &elbv2.FixedResponseActionConfig{
+					ContentType: aws.String("text/plain"),
+					MessageBody: aws.String(keyAuth),
+					StatusCode:  aws.String("200"),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(resp.Rules) == 0 {
+		return "", errors.New("CreateRule didn't return the rule")
+	}
+
+	return aws.StringValue(resp.Rules[0].RuleArn), nil
+}
+
+// listRules returns every rule on a listener
+func (s *Solver) listRules(ctx context.Context, listenerARN string) ([]*elbv2.Rule, error) {
+	var rules []*elbv2.Rule
+
+	in := &elbv2.DescribeRulesInput{
+		ListenerArn: aws.String(listenerARN),
+	}
+	for {
+		resp, err := s.client.DescribeRulesWithContext(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("describing rules on %v: %w", listenerARN, err)
+		}
+
+		rules = append(rules, resp.Rules...)
+
+		if aws.StringValue(resp.NextMarker) == "" {
+			return rules, nil
+		}
+		in.Marker = resp.NextMarker
+	}
+}
+
+// listListeners returns the ARN of every listener on the load balancer that
+// our listener belongs to
+func (s *Solver) listListeners(ctx context.Context) ([]string, error) {
+	resp, err := s.client.DescribeListenersWithContext(ctx, &elbv2.DescribeListenersInput{
+		ListenerArns: []*string{aws.String(s.listenerARN)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing listener %v: %w", s.listenerARN, err)
+	}
+	if len(resp.Listeners) == 0 {
+		return nil, fmt.Errorf("listener %v not found", s.listenerARN)
+	}
+
+	var listeners []string
+	in := &elbv2.DescribeListenersInput{
+		LoadBalancerArn: resp.Listeners[0].LoadBalancerArn,
+	}
+	for {
+		resp, err := s.client.DescribeListenersWithContext(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("describing listeners on %v: %w", aws.StringValue(in.LoadBalancerArn), err)
+		}
+
+		for _, l := range resp.Listeners {
+			listeners = append(listeners, aws.StringValue(l.ListenerArn))
+		}
+
+		if aws.StringValue(resp.NextMarker) == "" {
+			return listeners, nil
+		}
+		in.Marker = resp.NextMarker
+	}
+}
+
+// checkRuleLimit returns ErrRuleLimit if the load balancer already has
+// maxRules rules across all of its listeners
+func (s *Solver) checkRuleLimit(ctx context.Context) error {
+	listeners, err := s.listListeners(ctx)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, l := range listeners {
+		rules, err := s.listRules(ctx, l)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range rules {
+			// Default rules don't count towards the quota
+			if !aws.BoolValue(r.IsDefault) {
+				count++
+			}
+		}
+	}
+
+	if count >= s.maxRules {
+		return fmt.Errorf("%w: it has %d rules", ErrRuleLimit, count)
+	}
+
+	return nil
+}
+
+// freePriority returns the lowest unused priority from the first priority that
+// is ahead of every other rule on the listener, moving the rules to make one if
+// needed. Challenge rules don't overlap, so they can be in any order amongst
+// themselves.
+func (s *Solver) freePriority(ctx context.Context) (int, error) {
+	rules, err := s.listRules(ctx, s.listenerARN)
+	if err != nil {
+		return 0, err
+	}
+
+	limit := maxPriority + 1
+	used := map[int]bool{}
+	for _, r := range rules {
+		// The default rule has the priority "default" and is always last
+		if aws.BoolValue(r.IsDefault) {
+			continue
+		}
+
+		p, err := strconv.Atoi(aws.StringValue(r.Priority))
+		if err != nil {
+			continue
+		}
+		used[p] = true
+
+		if p >= s.firstPriority && p < limit && challengeToken(r) == "" {
+			limit = p
+		}
+	}
+
+	for p := s.firstPriority; p < limit; p++ {
+		if !used[p] {
+			return p, nil
+		}
+	}
+
+	// There's no gap ahead of the existing rules, so make one
+	if err := s.makeRoom(ctx, rules, limit); err != nil {
+		return 0, err
+	}
+
+	return limit, nil
+}
+
+// makeRoom frees priority p by moving the rule there, and any rules directly
+// after it, up by one. The original priorities are restored by CleanUp once
+// the Solver has no challenge rules left.
+func (s *Solver) makeRoom(ctx context.Context, rules []*elbv2.Rule, p int) error {
+	byPriority := map[int]*elbv2.Rule{}
+	for _, r := range rules {
+		if q, err := strconv.Atoi(aws.StringValue(r.Priority)); err == nil && !aws.BoolValue(r.IsDefault) {
+			byPriority[q] = r
+		}
+	}
+
+	var pairs []*elbv2.RulePriorityPair
+	for q := p; byPriority[q] != nil; q++ {
+		if q >= maxPriority {
+			return fmt.Errorf("%w: %v has no room to move its rules", ErrNoPriority, s.listenerARN)
+		}
+		pairs = append(pairs, &elbv2.RulePriorityPair{
+			RuleArn:  byPriority[q].RuleArn,
+			Priority: aws.Int64(int64(q + 1)),
+		})
+	}
+
+	log.Printf("[INFO] moving %d rule(s) on %v from priority %d to make room for a challenge", len(pairs), s.listenerARN, p)
+	_, err := s.client.SetRulePrioritiesWithContext(ctx, &elbv2.SetRulePrioritiesInput{
+		RulePriorities: pairs,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: moving the rules on %v: %v", ErrNoPriority, s.listenerARN, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, pair := range pairs {
+		arn := aws.StringValue(pair.RuleArn)
+		if _, ok := s.moved[arn]; !ok {
+			s.moved[arn] = p + i
+		}
+	}
+
+	return nil
+}
+
+// restorePriorities moves the rules that makeRoom moved back to their original
+// priorities, once the Solver has no challenge rules left
+func (s *Solver) restorePriorities(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.rules) > 0 || len(s.moved) == 0 {
+		return nil
+	}
+
+	var pairs []*elbv2.RulePriorityPair
+	for arn, p := range s.moved {
+		pairs = append(pairs, &elbv2.RulePriorityPair{
+			RuleArn:  aws.String(arn),
+			Priority: aws.Int64(int64(p)),
+		})
+	}
+
+	log.Printf("[INFO] restoring the priorities of %d rule(s) on %v", len(pairs), s.listenerARN)
+	_, err := s.client.SetRulePrioritiesWithContext(ctx, &elbv2.SetRulePrioritiesInput{
+		RulePriorities: pairs,
+	})
+	if err != nil {
+		return fmt.Errorf("restoring rule priorities on %v: %w", s.listenerARN, err)
+	}
+	s.moved = map[string]int{}
+
+	return nil
+}
+
+// challengeToken returns the token a challenge rule answers, or "" if the rule
+// isn't a challenge rule
+func challengeToken(r *elbv2.Rule) string {
+	for _, c := range r.Conditions {
+		if c.PathPatternConfig == nil {
+			continue
+		}
+		for _, v := range c.PathPatternConfig.Values {
+			if strings.HasPrefix(aws.StringValue(v), challengePath) {
+				return strings.TrimPrefix(aws.StringValue(v), challengePath)
+			}
+		}
+	}
+
+	return ""
+}
+
+// findRule returns the ARN of the rule for a token, or "" if there isn't one
+func (s *Solver) findRule(ctx context.Context, token string) (string, error) {
+	rules, err := s.listRules(ctx, s.listenerARN)
+	if err != nil {
+		return "", err
+	}
+
+	for _, r := range rules {
+		if challengeToken(r) == token {
+			return aws.StringValue(r.RuleArn), nil
+		}
+	}
+
+	return "", nil
+}
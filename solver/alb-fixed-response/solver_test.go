@@ -0,0 +1,334 @@
+package alb
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// fakeELB keeps the rules of the "arn:listener" listener in memory, two to a
+// page. The load balancer's other listeners have only a number of rules.
+type fakeELB struct {
+	elbv2iface.ELBV2API
+	rules  []*elbv2.Rule
+	next   int
+	others map[string]int
+
+	// steal is the number of creates to fail as if another writer took the
+	// priority first
+	steal   int
+	creates int
+	deletes int
+	moves   int
+}
+
+func newFakeELB(priorities ...int) *fakeELB {
+	f := &fakeELB{others: map[string]int{}}
+	f.rules = append(f.rules, &elbv2.Rule{
+		RuleArn:   aws.String("arn:default"),
+		Priority:  aws.String("default"),
+		IsDefault: aws.Bool(true),
+	})
+	for _, p := range priorities {
+		f.add(p, nil)
+	}
+
+	return f
+}
+
+func (f *fakeELB) add(priority int, in *elbv2.CreateRuleInput) *elbv2.Rule {
+	f.next++
+	r := &elbv2.Rule{
+		RuleArn:   aws.String(fmt.Sprintf("arn:rule/%d", f.next)),
+		Priority:  aws.String(strconv.Itoa(priority)),
+		IsDefault: aws.Bool(false),
+	}
+	if in != nil {
+		r.Conditions = in.Conditions
+		r.Actions = in.Actions
+	}
+	f.rules = append(f.rules, r)
+
+	return r
+}
+
+func (f *fakeELB) rule(priority int) *elbv2.Rule {
+	for _, r := range f.rules {
+		if aws.StringValue(r.Priority) == strconv.Itoa(priority) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeELB) DescribeListenersWithContext(ctx aws.Context, in *elbv2.DescribeListenersInput, opts ...request.Option) (*elbv2.DescribeListenersOutput, error) {
+	listener := func(arn string) *elbv2.Listener {
+		return &elbv2.Listener{ListenerArn: aws.String(arn), LoadBalancerArn: aws.String("arn:lb")}
+	}
+
+	if in.LoadBalancerArn == nil {
+		return &elbv2.DescribeListenersOutput{Listeners: []*elbv2.Listener{listener(aws.StringValue(in.ListenerArns[0]))}}, nil
+	}
+
+	out := &elbv2.DescribeListenersOutput{Listeners: []*elbv2.Listener{listener("arn:listener")}}
+	for arn := range f.others {
+		out.Listeners = append(out.Listeners, listener(arn))
+	}
+
+	return out, nil
+}
+
+func (f *fakeELB) DescribeRulesWithContext(ctx aws.Context, in *elbv2.DescribeRulesInput, opts ...request.Option) (*elbv2.DescribeRulesOutput, error) {
+	if n, ok := f.others[aws.StringValue(in.ListenerArn)]; ok {
+		out := &elbv2.DescribeRulesOutput{}
+		for i := 0; i < n; i++ {
+			out.Rules = append(out.Rules, &elbv2.Rule{Priority: aws.String(strconv.Itoa(i + 1)), IsDefault: aws.Bool(false)})
+		}
+		return out, nil
+	}
+
+	start := 0
+	if in.Marker != nil {
+		start, _ = strconv.Atoi(aws.StringValue(in.Marker))
+	}
+
+	end := start + 2
+	if end >= len(f.rules) {
+		return &elbv2.DescribeRulesOutput{Rules: f.rules[start:]}, nil
+	}
+
+	return &elbv2.DescribeRulesOutput{
+		Rules:      f.rules[start:end],
+		NextMarker: aws.String(strconv.Itoa(end)),
+	}, nil
+}
+
+func (f *fakeELB) CreateRuleWithContext(ctx aws.Context, in *elbv2.CreateRuleInput, opts ...request.Option) (*elbv2.CreateRuleOutput, error) {
+	f.creates++
+	priority := int(aws.Int64Value(in.Priority))
+
+	// Another Solver takes the priority for its own challenge
+	if f.steal > 0 {
+		f.steal--
+		f.add(priority, in)
+	}
+	if f.rule(priority) != nil {
+		return nil, awserr.New(elbv2.ErrCodePriorityInUseException, "priority in use", nil)
+	}
+
+	return &elbv2.CreateRuleOutput{Rules: []*elbv2.Rule{f.add(priority, in)}}, nil
+}
+
+func (f *fakeELB) SetRulePrioritiesWithContext(ctx aws.Context, in *elbv2.SetRulePrioritiesInput, opts ...request.Option) (*elbv2.SetRulePrioritiesOutput, error) {
+	f.moves++
+
+	// The new priorities are checked together, so rules can swap places
+	old := map[*elbv2.Rule]*string{}
+	for _, pair := range in.RulePriorities {
+		for _, r := range f.rules {
+			if aws.StringValue(r.RuleArn) == aws.StringValue(pair.RuleArn) {
+				old[r] = r.Priority
+				r.Priority = aws.String(strconv.FormatInt(aws.Int64Value(pair.Priority), 10))
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, r := range f.rules {
+		if seen[aws.StringValue(r.Priority)] {
+			for r, p := range old {
+				r.Priority = p
+			}
+			return nil, awserr.New(elbv2.ErrCodePriorityInUseException, "priority in use", nil)
+		}
+		seen[aws.StringValue(r.Priority)] = true
+	}
+
+	return &elbv2.SetRulePrioritiesOutput{}, nil
+}
+
+func (f *fakeELB) DeleteRuleWithContext(ctx aws.Context, in *elbv2.DeleteRuleInput, opts ...request.Option) (*elbv2.DeleteRuleOutput, error) {
+	f.deletes++
+	for i, r := range f.rules {
+		if aws.StringValue(r.RuleArn) == aws.StringValue(in.RuleArn) {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return &elbv2.DeleteRuleOutput{}, nil
+		}
+	}
+
+	return nil, awserr.New(elbv2.ErrCodeRuleNotFoundException, "rule not found", nil)
+}
+
+func TestPresentCleanUp(t *testing.T) {
+	f := newFakeELB(6, 5, 2)
+	s := New(f, "arn:listener")
+
+	if err := s.Present("www.example.com", "token", "token.keyauth"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rule goes ahead of every existing rule, including those past the
+	// first page
+	r := f.rule(1)
+	if r == nil {
+		t.Fatalf("Expected a rule with priority 1")
+	}
+	helpers.ExpectStringMatch(t, "www.example.com", aws.StringValue(r.Conditions[0].HostHeaderConfig.Values[0]))
+	helpers.ExpectStringMatch(t, "/.well-known/acme-challenge/token", aws.StringValue(r.Conditions[1].PathPatternConfig.Values[0]))
+	helpers.ExpectStringMatch(t, "token.keyauth", aws.StringValue(r.Actions[0].FixedResponseConfig.MessageBody))
+	helpers.ExpectStringMatch(t, "200", aws.StringValue(r.Actions[0].FixedResponseConfig.StatusCode))
+
+	if err := s.CleanUp("www.example.com", "token", "token.keyauth"); err != nil {
+		t.Fatal(err)
+	}
+	if f.rule(1) != nil {
+		t.Errorf("CleanUp left the rule in place")
+	}
+
+	// Cleaning up twice is fine
+	if err := s.CleanUp("www.example.com", "token", "token.keyauth"); err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 1, f.deletes)
+}
+
+func TestCleanUp_otherSolver(t *testing.T) {
+	f := newFakeELB()
+	if err := New(f, "arn:listener").WithFirstPriority(10).Present("www.example.com", "token", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new Solver finds the rule by its path
+	if err := New(f, "arn:listener").CleanUp("www.example.com", "token", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+	if f.rule(10) != nil {
+		t.Errorf("CleanUp left the rule in place")
+	}
+}
+
+func TestPresent_priorityInUse(t *testing.T) {
+	f := newFakeELB()
+	f.steal = 2
+	s := New(f, "arn:listener")
+
+	if err := s.Present("www.example.com", "token", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 3, f.creates)
+	if f.rule(3) == nil {
+		t.Errorf("Expected the rule to take priority 3")
+	}
+
+	// Give up eventually
+	f.steal = maxAttempts
+	if err := s.Present("www.example.com", "token2", "keyauth2"); err == nil {
+		t.Errorf("Expected an error when the priorities keep being taken")
+	}
+}
+
+func TestPresent_ruleLimit(t *testing.T) {
+	f := newFakeELB(1, 2, 3)
+	s := New(f, "arn:listener").WithMaxRules(3)
+
+	err := s.Present("www.example.com", "token", "keyauth")
+	if !errors.Is(err, ErrRuleLimit) {
+		t.Errorf("Expected ErrRuleLimit, got %v", err)
+	}
+	helpers.ExpectIntMatch(t, 0, f.creates)
+}
+
+func TestPresent_gap(t *testing.T) {
+	f := newFakeELB(3)
+	s := New(f, "arn:listener")
+
+	// Challenge rules can share the priorities ahead of the existing rule
+	for _, token := range []string{"token", "token2"} {
+		if err := s.Present("www.example.com", token, "keyauth"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	helpers.ExpectIntMatch(t, 0, f.moves)
+
+	// Rules below the first priority are meant to stay ahead
+	f = newFakeELB(1, 5)
+	if err := New(f, "arn:listener").WithFirstPriority(2).Present("www.example.com", "token", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+	if f.rule(2) == nil {
+		t.Errorf("Expected the rule to take priority 2")
+	}
+	helpers.ExpectIntMatch(t, 0, f.moves)
+}
+
+func TestPresent_makeRoom(t *testing.T) {
+	// The listener's rules start at priority 1
+	f := newFakeELB(1, 2, 4)
+	original := map[string]string{}
+	for _, r := range f.rules {
+		original[aws.StringValue(r.RuleArn)] = aws.StringValue(r.Priority)
+	}
+	s := New(f, "arn:listener")
+
+	for _, token := range []string{"token", "token2"} {
+		if err := s.Present("www.example.com", token, "keyauth"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The rules were moved up, in order, behind the challenges
+	for p, token := range map[int]string{1: "token", 2: "token2"} {
+		r := f.rule(p)
+		if r == nil || aws.StringValue(r.Conditions[1].PathPatternConfig.Values[0]) != challengePath+token {
+			t.Errorf("Expected the %v rule at priority %d", token, p)
+		}
+	}
+	for p, arn := range map[int]string{3: "arn:rule/1", 4: "arn:rule/2", 5: "arn:rule/3"} {
+		helpers.ExpectStringMatch(t, arn, aws.StringValue(f.rule(p).RuleArn))
+	}
+
+	// The original priorities are restored once the last challenge is cleaned up
+	if err := s.CleanUp("www.example.com", "token", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 2, f.moves)
+	if err := s.CleanUp("www.example.com", "token2", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 3, f.moves)
+	helpers.ExpectIntMatch(t, len(original), len(f.rules))
+	for _, r := range f.rules {
+		helpers.ExpectStringMatch(t, original[aws.StringValue(r.RuleArn)], aws.StringValue(r.Priority))
+	}
+}
+
+func TestPresent_noPriority(t *testing.T) {
+	f := newFakeELB(maxPriority)
+	err := New(f, "arn:listener").WithFirstPriority(maxPriority).Present("www.example.com", "token", "keyauth")
+	if !errors.Is(err, ErrNoPriority) {
+		t.Errorf("Expected ErrNoPriority, got %v", err)
+	}
+	helpers.ExpectIntMatch(t, 0, f.creates)
+}
+
+func TestPresent_ruleLimitOtherListeners(t *testing.T) {
+	f := newFakeELB(10)
+	f.others["arn:listener/https"] = 2
+	s := New(f, "arn:listener").WithMaxRules(3)
+
+	err := s.Present("www.example.com", "token", "keyauth")
+	if !errors.Is(err, ErrRuleLimit) {
+		t.Errorf("Expected ErrRuleLimit, got %v", err)
+	}
+	helpers.ExpectIntMatch(t, 0, f.creates)
+}
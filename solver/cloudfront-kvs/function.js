@@ -0,0 +1,63 @@
+// CloudFront Functions viewer-request handler that answers ACME HTTP-01
+// challenges from a KeyValueStore written by the acme-sls cloudfront-kvs solver.
+// Associate it with the viewer-request event of the default cache behaviour;
+// every other request passes through untouched.
+import cf from 'cloudfront';
+
+const kvs = cf.kvs('__KVS_ID__');
+const KEY_PREFIX = '__KEY_PREFIX__';
+const CHALLENGE_PREFIX = '/.well-known/acme-challenge/';
+
+function respond(statusCode, statusDescription, body) {
+    return {
+        statusCode: statusCode,
+        statusDescription: statusDescription,
+        headers: {
+            'content-type': { value: 'text/plain; charset=utf-8' },
+            'cache-control': { value: 'no-store' }
+        },
+        body: { encoding: 'text', data: body }
+    };
+}
+
+// normaliseHost strips the port and trailing dot, and lower-cases the host
+function normaliseHost(host) {
+    host = host.toLowerCase();
+    const colon = host.lastIndexOf(':');
+    if (colon !== -1 && host.indexOf(']') < colon) {
+        host = host.substring(0, colon);
+    }
+    if (host.endsWith('.')) {
+        host = host.substring(0, host.length - 1);
+    }
+    return host;
+}
+
+async function handler(event) {
+    const request = event.request;
+    const method = request.method;
+
+    if (!request.uri.startsWith(CHALLENGE_PREFIX) || (method !== 'GET' && method !== 'HEAD')) {
+        return request;
+    }
+
+    const token = request.uri.substring(CHALLENGE_PREFIX.length);
+    if (token === '' || token.indexOf('/') !== -1) {
+        return request;
+    }
+
+    let challenge;
+    try {
+        challenge = JSON.parse(await kvs.get(KEY_PREFIX + token));
+    } catch (err) {
+        return respond(404, 'Not Found', 'Challenge not found');
+    }
+
+    // Only answer for the domain the challenge was issued for
+    const host = request.headers.host ? normaliseHost(request.headers.host.value) : '';
+    if (host !== challenge.domain) {
+        return respond(404, 'Not Found', 'Challenge not found');
+    }
+
+    return respond(200, 'OK', challenge.keyAuth);
+}
@@ -0,0 +1,182 @@
+// package kvs solves the ACMEv2 HTTP-01 challenge at the CloudFront edge. The
+// workflow is as follows:
+//
+// 1. client requests a certificate from the remote CA, using the Solver as the HTTP-01 challenge
+// 2. Solver writes the Challenge to a CloudFront KeyValueStore, keyed by token
+// 3. remote CA requests the keyauth from the well known path on port 80
+// 4. a CloudFront Function on the viewer-request event reads the Challenge from the KeyValueStore and answers
+//
+// No origin or cache behaviour changes are needed; FunctionCode returns the code
+// for the function.
+package kvs
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	solver "github.com/sjauld/acme-sls/solver/http"
+	"github.com/sjauld/acme-sls/solver/selfcheck"
+)
+
+// KeyValueStoreAPI is the part of the CloudFront KeyValueStore API used by the
+// Solver. aws-sdk-go v1 doesn't include this service, so wrap whichever client
+// you use (e.g. the aws-sdk-go-v2 cloudfrontkeyvaluestore client) to satisfy it.
+//
+// Every write needs the current ETag of the store. Implementations should
+// return an error that wraps ErrConflict if the ETag is out of date, so that the
+// Solver can describe the store and try again.
+type KeyValueStoreAPI interface {
+	// DescribeKeyValueStore returns the current ETag of the store
+	DescribeKeyValueStore(ctx context.Context, kvsARN string) (string, error)
+	// PutKey writes a key, returning the new ETag of the store
+	PutKey(ctx context.Context, kvsARN, key, value, ifMatch string) (string, error)
+	// DeleteKey removes a key, returning the new ETag of the store. It must
+	// return an error that wraps ErrKeyNotFound if the key doesn't exist.
+	DeleteKey(ctx context.Context, kvsARN, key, ifMatch string) (string, error)
+}
+
+var (
+	// ErrConflict means that the ETag passed to a write is out of date
+	ErrConflict = errors.New("the KeyValueStore has changed")
+	// ErrKeyNotFound means that the key doesn't exist
+	ErrKeyNotFound = errors.New("key not found")
+)
+
+// DefaultKeyPrefix is prepended to the token to form the key, so that the store
+// can be shared with other data
+const DefaultKeyPrefix = "acme-challenge:"
+
+// maxAttempts is how many times a write is tried when other writers keep
+// changing the store
+const maxAttempts = 5
+
+//go:embed function.js
+var functionTemplate string
+
+// FunctionCode returns the code for a CloudFront Function (JavaScript runtime
+// 2.0) that answers challenges from the KeyValueStore. kvsID is the ID of the
+// store, which must also be associated with the function, and keyPrefix must
+// match the Solver's.
+func FunctionCode(kvsID, keyPrefix string) string {
+	return strings.NewReplacer(
+		"__KVS_ID__", kvsID,
+		"__KEY_PREFIX__", keyPrefix,
+	).Replace(functionTemplate)
+}
+
+// Solver implements lego's challenge.Provider
+type Solver struct {
+	ctx       context.Context
+	client    KeyValueStoreAPI
+	kvsARN    string
+	keyPrefix string
+
+	// CloudFront takes a few seconds to propagate a write to every edge
+	selfCheck *selfcheck.Checker
+}
+
+// New returns a pointer to a Solver, initialised with a KeyValueStore client and
+// the ARN of the store
+func New(client KeyValueStoreAPI, kvsARN string) *Solver {
+	return &Solver{
+		ctx:       context.Background(),
+		client:    client,
+		kvsARN:    kvsARN,
+		keyPrefix: DefaultKeyPrefix,
+		selfCheck: selfcheck.New(),
+	}
+}
+
+// WithContext sets the context used by Present and CleanUp
+func (s *Solver) WithContext(ctx context.Context) *Solver {
+	s.ctx = ctx
+	return s
+}
+
+// WithKeyPrefix allows you to override DefaultKeyPrefix
+func (s *Solver) WithKeyPrefix(prefix string) *Solver {
+	s.keyPrefix = prefix
+	return s
+}
+
+// WithSelfCheck overrides the check that Present uses to wait until the edge
+// serves the keyauth, e.g. to change the timeout. Pass nil to notify the CA as
+// soon as the key is written.
+func (s *Solver) WithSelfCheck(c *selfcheck.Checker) *Solver {
+	s.selfCheck = c
+	return s
+}
+
+// Present writes the challenge information into the KeyValueStore so that the
+// CloudFront Function can respond with the correct value
+func (s *Solver) Present(domain, token, keyAuth string) error {
+	return s.PresentWithContext(s.ctx, domain, token, keyAuth)
+}
+
+// PresentWithContext is the same as Present with the addition of the ability
+// to pass a context
+func (s *Solver) PresentWithContext(ctx context.Context, domain, token, keyAuth string) error {
+	log.Printf("[INFO] Presenting domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
+
+	// The function parses the same JSON that the other HTTP-01 stores use
+	value, err := json.Marshal(solver.NewChallenge(domain, token, keyAuth))
+	if err != nil {
+		return err
+	}
+
+	err = s.write(ctx, func(etag string) (string, error) {
+		return s.client.PutKey(ctx, s.kvsARN, s.keyPrefix+token, string(value), etag)
+	})
+	if err != nil || s.selfCheck == nil {
+		return err
+	}
+
+	return s.selfCheck.HTTP01(ctx, domain, token, keyAuth)
+}
+
+// CleanUp removes the challenge information from the KeyValueStore
+func (s *Solver) CleanUp(domain, token, keyAuth string) error {
+	return s.CleanUpWithContext(s.ctx, domain, token, keyAuth)
+}
+
+// CleanUpWithContext is the same as CleanUp with the addition of the ability
+// to pass a context
+func (s *Solver) CleanUpWithContext(ctx context.Context, domain, token, keyAuth string) error {
+	log.Printf("[INFO] CleaningUp domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
+
+	err := s.write(ctx, func(etag string) (string, error) {
+		return s.client.DeleteKey(ctx, s.kvsARN, s.keyPrefix+token, etag)
+	})
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// write performs a write with the current ETag of the store, describing the
+// store again if another writer got there first
+func (s *Solver) write(ctx context.Context, f func(etag string) (string, error)) error {
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		var etag string
+		etag, err = s.client.DescribeKeyValueStore(ctx, s.kvsARN)
+		if err != nil {
+			return fmt.Errorf("describing KeyValueStore %v: %w", s.kvsARN, err)
+		}
+
+		_, err = f(etag)
+		if !errors.Is(err, ErrConflict) {
+			return err
+		}
+
+		log.Printf("[DEBUG] KeyValueStore %v changed during the write, retrying", s.kvsARN)
+	}
+
+	return fmt.Errorf("writing to KeyValueStore %v: %w", s.kvsARN, err)
+}
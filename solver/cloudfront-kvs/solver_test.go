@@ -0,0 +1,177 @@
+package kvs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sjauld/acme-sls/helpers"
+	solver "github.com/sjauld/acme-sls/solver/http"
+	"github.com/sjauld/acme-sls/solver/selfcheck"
+)
+
+// fakeKVS is an in-memory KeyValueStore that checks ETags
+type fakeKVS struct {
+	keys    map[string]string
+	version int
+
+	// conflicts is the number of writes to fail as if another writer changed
+	// the store
+	conflicts int
+	writes    int
+}
+
+func newFakeKVS() *fakeKVS {
+	return &fakeKVS{keys: map[string]string{}}
+}
+
+func (f *fakeKVS) etag() string {
+	return fmt.Sprintf("etag-%d", f.version)
+}
+
+func (f *fakeKVS) DescribeKeyValueStore(ctx context.Context, kvsARN string) (string, error) {
+	return f.etag(), nil
+}
+
+func (f *fakeKVS) checkETag(ifMatch string) error {
+	f.writes++
+	if f.conflicts > 0 {
+		f.conflicts--
+		f.version++
+	}
+	if ifMatch != f.etag() {
+		return fmt.Errorf("precondition failed: %w", ErrConflict)
+	}
+
+	f.version++
+	return nil
+}
+
+func (f *fakeKVS) PutKey(ctx context.Context, kvsARN, key, value, ifMatch string) (string, error) {
+	if err := f.checkETag(ifMatch); err != nil {
+		return "", err
+	}
+
+	f.keys[key] = value
+	return f.etag(), nil
+}
+
+func (f *fakeKVS) DeleteKey(ctx context.Context, kvsARN, key, ifMatch string) (string, error) {
+	if _, ok := f.keys[key]; !ok {
+		return "", ErrKeyNotFound
+	}
+	if err := f.checkETag(ifMatch); err != nil {
+		return "", err
+	}
+
+	delete(f.keys, key)
+	return f.etag(), nil
+}
+
+func TestPresentCleanUp(t *testing.T) {
+	f := newFakeKVS()
+	s := New(f, "arn:aws:cloudfront::123456789012:key-value-store/test").WithSelfCheck(nil)
+
+	err := s.Present("WWW.example.com", "token", "token.keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ch solver.Challenge
+	if err := json.Unmarshal([]byte(f.keys["acme-challenge:token"]), &ch); err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectStringMatch(t, "www.example.com", ch.Domain)
+	helpers.ExpectStringMatch(t, "token.keyauth", ch.KeyAuth)
+
+	err = s.CleanUp("www.example.com", "token", "token.keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 0, len(f.keys))
+
+	// Cleaning up twice is fine
+	err = s.CleanUp("www.example.com", "token", "token.keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPresent_conflict(t *testing.T) {
+	f := newFakeKVS()
+	f.conflicts = 2
+	s := New(f, "arn").WithKeyPrefix("p/").WithSelfCheck(nil)
+
+	err := s.Present("www.example.com", "token", "token.keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 3, f.writes)
+	if _, ok := f.keys["p/token"]; !ok {
+		t.Errorf("Expected the key to be written with the prefix")
+	}
+
+	// Give up eventually
+	f.conflicts = maxAttempts
+	err = s.Present("www.example.com", "token2", "token2.keyauth")
+	if err == nil {
+		t.Errorf("Expected an error when the store keeps changing")
+	}
+}
+
+// edge answers challenges from the fake store, standing in for the CloudFront
+// Function. Until propagated is set it answers as an edge that hasn't seen the
+// write yet.
+type edge struct {
+	f          *fakeKVS
+	propagated bool
+}
+
+func (e *edge) RoundTrip(req *http.Request) (*http.Response, error) {
+	res := &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader("Challenge not found"))}
+
+	var ch solver.Challenge
+	if err := json.Unmarshal([]byte(e.f.keys[DefaultKeyPrefix+path.Base(req.URL.Path)]), &ch); err == nil && e.propagated {
+		res.StatusCode = http.StatusOK
+		res.Body = ioutil.NopCloser(strings.NewReader(ch.KeyAuth))
+	}
+
+	return res, nil
+}
+
+func TestPresent_selfCheck(t *testing.T) {
+	f := newFakeKVS()
+	e := &edge{f: f, propagated: true}
+	check := selfcheck.New().WithInterval(time.Millisecond).WithTimeout(50 * time.Millisecond).WithHTTPClient(&http.Client{Transport: e})
+	s := New(f, "arn").WithSelfCheck(check)
+
+	if err := s.Present("www.example.com", "token", "token.keyauth"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The CA isn't notified until the edge serves the keyauth
+	e.propagated = false
+	if err := s.Present("www.example.com", "token2", "token2.keyauth"); err == nil {
+		t.Errorf("Expected an error when the edge doesn't serve the challenge")
+	}
+}
+
+func TestFunctionCode(t *testing.T) {
+	code := FunctionCode("kvs-id", "acme-challenge:")
+
+	if !strings.Contains(code, "cf.kvs('kvs-id')") {
+		t.Errorf("Function code doesn't use the KeyValueStore ID")
+	}
+	if !strings.Contains(code, "const KEY_PREFIX = 'acme-challenge:'") {
+		t.Errorf("Function code doesn't use the key prefix")
+	}
+	if strings.Contains(code, "__") {
+		t.Errorf("Function code has unreplaced placeholders")
+	}
+}
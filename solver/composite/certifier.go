@@ -0,0 +1,155 @@
+package composite
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/go-acme/lego/v4/acme/api"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/resolver"
+	"github.com/go-acme/lego/v4/lego"
+)
+
+// Failure is a challenge that didn't validate a domain
+type Failure struct {
+	Rule Rule
+	Err  error
+}
+
+// Result records how a domain was validated
+type Result struct {
+	Domain string
+	// Rule is the rule that validated the domain, or nil if it wasn't
+	Rule *Rule
+	// Reused is true if the CA already had a valid authorization for the domain
+	Reused bool
+	// Failures are the rules that were tried first, in order
+	Failures []Failure
+}
+
+// String describes the result, e.g. "example.com: http-01 (s3)"
+func (r Result) String() string {
+	var method string
+	switch {
+	case r.Rule != nil:
+		method = r.Rule.String()
+	case r.Reused:
+		method = "already valid"
+	default:
+		method = "not validated"
+	}
+
+	if len(r.Failures) == 0 {
+		return fmt.Sprintf("%v: %v", r.Domain, method)
+	}
+
+	var failed []string
+	for _, f := range r.Failures {
+		failed = append(failed, f.Rule.String())
+	}
+
+	return fmt.Sprintf("%v: %v, after %v failed", r.Domain, method, strings.Join(failed, ", "))
+}
+
+// Certifier is a lego Certifier whose Obtain falls back to the next rule for
+// the domains that failed
+type Certifier struct {
+	*certificate.Certifier
+	dispatcher *dispatcher
+}
+
+// NewCertifier returns a Certifier that solves each authorization with the
+// matching rule. It makes its own connection to the CA, so call it after the
+// user is registered, and use it in place of the lego Client's Certificate.
+func (s *Solver) NewCertifier(config *lego.Config) (*Certifier, error) {
+	var kid string
+	if reg := config.User.GetRegistration(); reg != nil {
+		kid = reg.URI
+	}
+
+	core, err := api.New(config.HTTPClient, config.UserAgent, config.CADirURL, kid, config.User.GetPrivateKey())
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := s.dispatcher(func(r Rule) (authzSolver, error) {
+		return newProber(core, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Certifier{
+		Certifier: certificate.NewCertifier(core, d, certificate.CertifierOptions{
+			KeyType: config.Certificate.KeyType,
+			Timeout: config.Certificate.Timeout,
+		}),
+		dispatcher: d,
+	}, nil
+}
+
+// newProber returns a lego Prober that only knows about the rule's challenge
+// type, so that lego can't choose another one
+func newProber(core *api.Core, r Rule) (authzSolver, error) {
+	sm := resolver.NewSolversManager(core)
+
+	var err error
+	switch r.Type {
+	case challenge.HTTP01:
+		err = sm.SetHTTP01Provider(r.Provider)
+	case challenge.TLSALPN01:
+		err = sm.SetTLSALPN01Provider(r.Provider)
+	case challenge.DNS01:
+		err = sm.SetDNS01Provider(r.Provider, r.DNSOptions...)
+	default:
+		err = fmt.Errorf("unsupported challenge type %q for %v", r.Type, r.Pattern)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return resolver.NewProber(sm), nil
+}
+
+// Obtain obtains a certificate like lego's Certifier. If some challenges fail,
+// and each of those domains has another rule, it places a new order and tries
+// them with their next rule. The CA reuses the authorizations that succeeded.
+func (c *Certifier) Obtain(request certificate.ObtainRequest) (*certificate.Resource, error) {
+	return obtain(c.Certifier.Obtain, c.dispatcher, request)
+}
+
+// Results returns how each domain was validated, sorted by domain
+func (c *Certifier) Results() []Result {
+	return c.dispatcher.sortedResults()
+}
+
+// obtain calls obtainFunc until it succeeds, or fails for a reason that another
+// rule can't fix
+func obtain(obtainFunc func(certificate.ObtainRequest) (*certificate.Resource, error), d *dispatcher, request certificate.ObtainRequest) (*certificate.Resource, error) {
+	for {
+		cert, err := obtainFunc(request)
+
+		var errs Errors
+		if err == nil || !errors.As(err, &errs) || !d.fallback(errs) {
+			return cert, err
+		}
+
+		log.Printf("[INFO] retrying with fallback challenges: %v", err)
+	}
+}
+
+func (d *dispatcher) sortedResults() []Result {
+	var results []Result
+	for _, r := range d.results {
+		results = append(results, *r)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Domain < results[j].Domain
+	})
+
+	return results
+}
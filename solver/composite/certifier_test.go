@@ -0,0 +1,150 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// fakeCA places orders through the dispatcher, reusing the authorizations that
+// were validated by earlier orders like a real CA
+type fakeCA struct {
+	d      *dispatcher
+	valid  map[string]bool
+	orders int
+}
+
+func (ca *fakeCA) Obtain(request certificate.ObtainRequest) (*certificate.Resource, error) {
+	ca.orders++
+
+	var authzs []acme.Authorization
+	for _, domain := range request.Domains {
+		a := authz(domain, false)
+		if ca.valid[domain] {
+			a.Status = acme.StatusValid
+		}
+		authzs = append(authzs, a)
+	}
+
+	err := ca.d.Solve(authzs)
+	errs, _ := err.(Errors)
+	for _, a := range authzs {
+		if errs[a.Identifier.Value] == nil {
+			ca.valid[a.Identifier.Value] = true
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &certificate.Resource{Domain: request.Domains[0]}, nil
+}
+
+func TestObtain_fallback(t *testing.T) {
+	s := New(
+		Rule{Pattern: "*", Type: challenge.HTTP01, Provider: fakeProvider{}, Name: "s3"},
+		Rule{Pattern: "*", Type: challenge.DNS01, Provider: fakeProvider{}, Name: "route53"},
+	)
+
+	// HTTP-01 fails for the domain whose CNAME doesn't exist yet
+	http := &fakeSolver{fail: map[string]bool{"new.example.com": true}}
+	dns := &fakeSolver{}
+	d, err := s.dispatcher(func(r Rule) (authzSolver, error) {
+		if r.Type == challenge.DNS01 {
+			return dns, nil
+		}
+		return http, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &fakeCA{d: d, valid: map[string]bool{"old.example.com": true}}
+	request := certificate.ObtainRequest{Domains: []string{"example.com", "new.example.com", "old.example.com"}}
+	if _, err := obtain(ca.Obtain, d, request); err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 2, ca.orders)
+
+	// example.com was only solved once, as its authorization was reused
+	helpers.ExpectIntMatch(t, 1, len(http.solved))
+	helpers.ExpectIntMatch(t, 1, len(dns.solved))
+	helpers.ExpectStringMatch(t, "new.example.com", dns.solved[0])
+
+	results := d.sortedResults()
+	helpers.ExpectIntMatch(t, 3, len(results))
+	helpers.ExpectStringMatch(t, "example.com: http-01 (s3)", results[0].String())
+	helpers.ExpectStringMatch(t, "new.example.com: dns-01 (route53), after http-01 (s3) failed", results[1].String())
+	helpers.ExpectStringMatch(t, "old.example.com: already valid", results[2].String())
+}
+
+func TestObtain_noFallback(t *testing.T) {
+	s := New(Rule{Pattern: "*", Type: challenge.HTTP01, Provider: fakeProvider{}})
+
+	f := &fakeSolver{fail: map[string]bool{"example.com": true}}
+	d, err := s.dispatcher(func(r Rule) (authzSolver, error) {
+		return f, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// There's nothing else to try, so the first error is returned
+	ca := &fakeCA{d: d, valid: map[string]bool{}}
+	if _, err := obtain(ca.Obtain, d, certificate.ObtainRequest{Domains: []string{"example.com"}}); err == nil {
+		t.Errorf("Expected an error")
+	}
+	helpers.ExpectIntMatch(t, 1, ca.orders)
+	helpers.ExpectStringMatch(t, "example.com: not validated, after http-01 failed", d.sortedResults()[0].String())
+}
+
+// cancellingSolver cancels the context when it is asked to solve
+type cancellingSolver struct {
+	fakeSolver
+	cancel context.CancelFunc
+}
+
+func (c *cancellingSolver) Solve(authorizations []acme.Authorization) error {
+	c.cancel()
+	return c.fakeSolver.Solve(authorizations)
+}
+
+func TestObtain_deadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(
+		Rule{Pattern: "*", Type: challenge.HTTP01, Provider: fakeProvider{}},
+		Rule{Pattern: "*", Type: challenge.DNS01, Provider: fakeProvider{}},
+	).WithContext(ctx)
+
+	// The time runs out while the first domain is being solved
+	http := &cancellingSolver{fakeSolver: fakeSolver{fail: map[string]bool{"a.example.com": true}}, cancel: cancel}
+	dns := &fakeSolver{}
+	d, err := s.dispatcher(func(r Rule) (authzSolver, error) {
+		if r.Type == challenge.DNS01 {
+			return dns, nil
+		}
+		return http, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &fakeCA{d: d, valid: map[string]bool{}}
+	_, err = obtain(ca.Obtain, d, certificate.ObtainRequest{Domains: []string{"a.example.com", "b.example.com"}})
+
+	// Neither the second domain nor the fallback is tried
+	var errs Errors
+	if !errors.As(err, &errs) || !errors.Is(errs["b.example.com"], context.Canceled) {
+		t.Errorf("Expected b.example.com to fail with context.Canceled, got %v", err)
+	}
+	helpers.ExpectIntMatch(t, 1, ca.orders)
+	helpers.ExpectIntMatch(t, 0, len(http.solved))
+	helpers.ExpectIntMatch(t, 0, len(dns.solved))
+}
@@ -0,0 +1,292 @@
+// package composite solves each domain on a certificate with its own challenge
+// type and provider, e.g. HTTP-01 via S3 for the apex and DNS-01 for a
+// wildcard.
+//
+// lego only lets you register one provider per challenge type, and always
+// prefers TLS-ALPN-01 over HTTP-01 over DNS-01 when the CA offers more than
+// one, so the Solver replaces lego's resolver instead: NewCertifier returns a
+// Certifier that looks up the Rule for each authorization and solves it with
+// that Rule alone.
+//
+// When more than one rule matches a domain, the later ones are fallbacks: if
+// the challenge fails, the Certifier places a new order and tries the domain
+// with the next rule.
+package composite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+
+	solver "github.com/sjauld/acme-sls/solver/http"
+)
+
+// Rule chooses how to solve the challenge for the domains matching Pattern
+type Rule struct {
+	// Pattern is a domain name, "*.example.com" for every name under
+	// example.com (including the wildcard itself), or "*" for every domain
+	Pattern string
+	// Type is the challenge to solve, e.g. challenge.HTTP01
+	Type challenge.Type
+	// Provider presents and cleans up the challenge
+	Provider challenge.Provider
+	// Name describes the provider in results, e.g. "s3"
+	Name string
+	// DNSOptions are passed to lego when Type is challenge.DNS01
+	DNSOptions []dns01.ChallengeOption
+}
+
+// Matches reports whether the rule applies to the domain. Both are normalised
+// with solver/http's NormaliseDomain first.
+func (r Rule) Matches(domain string) bool {
+	pattern := solver.NormaliseDomain(r.Pattern)
+	domain = solver.NormaliseDomain(domain)
+
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "*."):
+		return strings.HasSuffix(domain, pattern[1:])
+	default:
+		return domain == pattern
+	}
+}
+
+// String describes the rule's method, e.g. "http-01 (s3)"
+func (r Rule) String() string {
+	if r.Name == "" {
+		return r.Type.String()
+	}
+
+	return fmt.Sprintf("%v (%v)", r.Type, r.Name)
+}
+
+// canSolve reports whether the rule applies to the domain and its challenge
+// type can validate it. Wildcards can only be validated with DNS-01.
+func (r Rule) canSolve(domain string) bool {
+	if strings.HasPrefix(domain, "*.") && r.Type != challenge.DNS01 {
+		return false
+	}
+
+	return r.Matches(domain)
+}
+
+// Errors is returned when some domains couldn't be solved, with the error for
+// each of them
+type Errors map[string]error
+
+func (e Errors) Error() string {
+	buffer := bytes.NewBufferString("one or more domains had a problem:\n")
+
+	var domains []string
+	for domain := range e {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	for _, domain := range domains {
+		fmt.Fprintf(buffer, "[%s] %s\n", domain, e[domain])
+	}
+
+	return buffer.String()
+}
+
+// authzSolver solves a batch of authorizations. lego's resolver.Prober is one.
+type authzSolver interface {
+	Solve(authorizations []acme.Authorization) error
+}
+
+// Solver holds the rules, in order of precedence
+type Solver struct {
+	ctx   context.Context
+	rules []Rule
+}
+
+// New returns a pointer to a Solver with the rules. The first rule that can
+// solve a domain is used, and any others are fallbacks.
+func New(rules ...Rule) *Solver {
+	return &Solver{ctx: context.Background(), rules: rules}
+}
+
+// WithContext sets the context that bounds the Certifier. No challenge or
+// fallback is started once it is done, so give it a deadline that leaves time
+// to finish the order, and give the providers the same context so that their
+// waits end by then too.
+func (s *Solver) WithContext(ctx context.Context) *Solver {
+	s.ctx = ctx
+	return s
+}
+
+// WithRule adds a rule after the existing ones
+func (s *Solver) WithRule(r Rule) *Solver {
+	s.rules = append(s.rules, r)
+	return s
+}
+
+// Rule returns the first rule that can solve the domain
+func (s *Solver) Rule(domain string) (Rule, bool) {
+	i := s.next(domain, -1)
+	if i < 0 {
+		return Rule{}, false
+	}
+
+	return s.rules[i], true
+}
+
+// Validate checks that every domain has a rule that can solve it, so that you
+// can fail before placing an order
+func (s *Solver) Validate(domains []string) error {
+	errs := Errors{}
+	for _, domain := range domains {
+		if _, ok := s.Rule(domain); !ok {
+			errs[domain] = noRuleError(domain)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// next returns the index of the first rule after the given one that can solve
+// the domain, or -1
+func (s *Solver) next(domain string, after int) int {
+	for i := after + 1; i < len(s.rules); i++ {
+		if s.rules[i].canSolve(domain) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func noRuleError(domain string) error {
+	if strings.HasPrefix(domain, "*.") {
+		return fmt.Errorf("no %v challenge rule matches %v", challenge.DNS01, domain)
+	}
+
+	return fmt.Errorf("no challenge rule matches %v", domain)
+}
+
+// dispatcher implements lego's resolver by handing each authorization to the
+// solver for the domain's current rule. It remembers the outcome for each
+// domain across orders, so that a failed domain can move on to its next rule.
+type dispatcher struct {
+	ctx     context.Context
+	solver  *Solver
+	solvers []authzSolver
+
+	// current is the index of the rule in use for each domain
+	current map[string]int
+	results map[string]*Result
+}
+
+// dispatcher builds a solver for every rule
+func (s *Solver) dispatcher(newSolver func(Rule) (authzSolver, error)) (*dispatcher, error) {
+	d := &dispatcher{
+		ctx:     s.ctx,
+		solver:  s,
+		current: map[string]int{},
+		results: map[string]*Result{},
+	}
+	for _, r := range s.rules {
+		as, err := newSolver(r)
+		if err != nil {
+			return nil, err
+		}
+		d.solvers = append(d.solvers, as)
+	}
+
+	return d, nil
+}
+
+// Solve solves the authorizations one at a time, so that a failure is reported
+// against the right domain. Authorizations left when the context is done are
+// failed without being tried.
+func (d *dispatcher) Solve(authorizations []acme.Authorization) error {
+	errs := Errors{}
+	for _, authz := range authorizations {
+		domain := challenge.GetTargetedDomain(authz)
+		result := d.result(domain)
+
+		if authz.Status == acme.StatusValid {
+			log.Printf("[INFO] %v is already authorized", domain)
+			// Keep the method if we validated it in an earlier order
+			if result.Rule == nil {
+				result.Reused = true
+			}
+			continue
+		}
+
+		i, ok := d.current[domain]
+		if !ok {
+			i = d.solver.next(domain, -1)
+			d.current[domain] = i
+		}
+		if i < 0 {
+			errs[domain] = noRuleError(domain)
+			continue
+		}
+
+		r := d.solver.rules[i]
+		if err := d.ctx.Err(); err != nil {
+			errs[domain] = fmt.Errorf("no time left to try %v: %w", r, err)
+			continue
+		}
+
+		log.Printf("[INFO] solving %v with %v", domain, r)
+		if err := d.solvers[i].Solve([]acme.Authorization{authz}); err != nil {
+			log.Printf("[ERROR] %v failed with %v: %v", domain, r, err)
+			result.Failures = append(result.Failures, Failure{Rule: r, Err: err})
+			errs[domain] = err
+			continue
+		}
+
+		result.Rule = &r
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// fallback moves each failed domain on to its next rule. It returns false if
+// any of them has run out of rules, or the context is done, as the order can't
+// succeed.
+func (d *dispatcher) fallback(errs Errors) bool {
+	if d.ctx.Err() != nil {
+		return false
+	}
+
+	ok := true
+	for domain := range errs {
+		i := d.solver.next(domain, d.current[domain])
+		if i < 0 {
+			ok = false
+			continue
+		}
+
+		log.Printf("[INFO] falling back to %v for %v", d.solver.rules[i], domain)
+		d.current[domain] = i
+	}
+
+	return ok
+}
+
+// result returns the result for a domain, creating it if needed
+func (d *dispatcher) result(domain string) *Result {
+	if _, ok := d.results[domain]; !ok {
+		d.results[domain] = &Result{Domain: domain}
+	}
+
+	return d.results[domain]
+}
@@ -0,0 +1,154 @@
+package composite
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/challenge"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// fakeProvider is a challenge.Provider that does nothing
+type fakeProvider struct{}
+
+func (fakeProvider) Present(domain, token, keyAuth string) error { return nil }
+func (fakeProvider) CleanUp(domain, token, keyAuth string) error { return nil }
+
+// fakeSolver records the domains it is asked to solve, and fails some of them
+type fakeSolver struct {
+	solved []string
+	fail   map[string]bool
+}
+
+func (f *fakeSolver) Solve(authorizations []acme.Authorization) error {
+	for _, authz := range authorizations {
+		domain := challenge.GetTargetedDomain(authz)
+		if f.fail[domain] {
+			return errors.New("validation failed")
+		}
+		f.solved = append(f.solved, domain)
+	}
+
+	return nil
+}
+
+func authz(domain string, wildcard bool) acme.Authorization {
+	return acme.Authorization{
+		Status:     acme.StatusPending,
+		Identifier: acme.Identifier{Type: "dns", Value: domain},
+		Wildcard:   wildcard,
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		pattern, domain string
+		expected        bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com", true},
+		{"example.com", "www.example.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "*.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+		{"*", "anything.test", true},
+		{"Example.com.", "example.com", true},
+		{"example.com", "example.com.", true},
+		{"bücher.example", "xn--bcher-kva.example", true},
+		{"*.bücher.example", "www.xn--bcher-kva.example", true},
+	}
+
+	for _, tt := range tests {
+		if got := (Rule{Pattern: tt.pattern}).Matches(tt.domain); got != tt.expected {
+			t.Errorf("%v matches %v: expected %v, got %v", tt.pattern, tt.domain, tt.expected, got)
+		}
+	}
+}
+
+func TestSolve(t *testing.T) {
+	s := New(
+		Rule{Pattern: "*.example.com", Type: challenge.DNS01, Provider: fakeProvider{}},
+		Rule{Pattern: "example.com", Type: challenge.HTTP01, Provider: fakeProvider{}},
+	)
+
+	var solvers []*fakeSolver
+	d, err := s.dispatcher(func(r Rule) (authzSolver, error) {
+		f := &fakeSolver{}
+		solvers = append(solvers, f)
+		return f, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := authz("www.example.com", false)
+	valid.Status = acme.StatusValid
+
+	err = d.Solve([]acme.Authorization{
+		authz("example.com", false),
+		authz("example.com", true),
+		valid,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each domain went to the solver for its rule, and valid ones were skipped
+	helpers.ExpectIntMatch(t, 1, len(solvers[0].solved))
+	helpers.ExpectStringMatch(t, "*.example.com", solvers[0].solved[0])
+	helpers.ExpectIntMatch(t, 1, len(solvers[1].solved))
+	helpers.ExpectStringMatch(t, "example.com", solvers[1].solved[0])
+}
+
+func TestSolve_errors(t *testing.T) {
+	s := New(Rule{Pattern: "*.example.com", Type: challenge.HTTP01, Provider: fakeProvider{}})
+
+	f := &fakeSolver{fail: map[string]bool{"bad.example.com": true}}
+	d, err := s.dispatcher(func(r Rule) (authzSolver, error) {
+		return f, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.Solve([]acme.Authorization{
+		authz("bad.example.com", false),
+		authz("good.example.com", false),
+		authz("other.test", false),
+	})
+
+	// The failures are reported per domain, and don't stop the other domains
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected Errors, got %v", err)
+	}
+	helpers.ExpectIntMatch(t, 2, len(errs))
+	if errs["bad.example.com"] == nil || errs["other.test"] == nil {
+		t.Errorf("Expected errors for bad.example.com and other.test, got %v", errs)
+	}
+	helpers.ExpectIntMatch(t, 1, len(f.solved))
+}
+
+func TestValidate(t *testing.T) {
+	s := New().
+		WithRule(Rule{Pattern: "*.example.com", Type: challenge.DNS01, Provider: fakeProvider{}}).
+		WithRule(Rule{Pattern: "*", Type: challenge.HTTP01, Provider: fakeProvider{}})
+
+	if err := s.Validate([]string{"example.com", "*.example.com", "www.example.com"}); err != nil {
+		t.Error(err)
+	}
+
+	// HTTP-01 can't validate a wildcard
+	if err := s.Validate([]string{"*.other.test"}); err == nil {
+		t.Errorf("Expected an error for a wildcard with HTTP-01")
+	}
+
+	// Every domain needs a rule
+	if err := New().Validate([]string{"example.com"}); err == nil {
+		t.Errorf("Expected an error for a domain without a rule")
+	}
+}
@@ -0,0 +1,90 @@
+package s3
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	solver "github.com/sjauld/acme-sls/solver/http"
+)
+
+// Target is the location in S3 that a domain's challenges are written to
+type Target struct {
+	Bucket    string
+	KeyPrefix string
+}
+
+// key returns the object key for a token. The request from ACME will be to
+// http://<domain>/.well-known/acme-challenge/<token>, so the prefix must match
+// the origin path of whatever serves the bucket.
+func (t Target) key(token string) string {
+	return path.Join(t.KeyPrefix, ".well-known/acme-challenge", token)
+}
+
+// Mapper returns the Target for a domain
+type Mapper func(domain string) (Target, error)
+
+// DomainBucket is the default Mapper. It writes to a bucket named after the
+// domain, which is what the S3 website endpoint needs to route requests for
+// the domain to the bucket.
+func DomainBucket(domain string) (Target, error) {
+	return Target{Bucket: domain}, nil
+}
+
+// SharedBucket returns a Mapper that writes the challenges for every domain to
+// one bucket, e.g. a bucket behind a CloudFront distribution that serves
+// /.well-known/acme-challenge/* for many domains. Tokens are unique, so domains
+// don't need their own prefix.
+func SharedBucket(bucket, keyPrefix string) Mapper {
+	return func(string) (Target, error) {
+		return Target{Bucket: bucket, KeyPrefix: keyPrefix}, nil
+	}
+}
+
+// StaticMapper returns a Mapper that looks domains up in targets, falling back
+// to fallback for domains that aren't listed. If fallback is nil, unlisted
+// domains are an error.
+func StaticMapper(targets map[string]Target, fallback Mapper) Mapper {
+	normalised := make(map[string]Target, len(targets))
+	for domain, t := range targets {
+		normalised[solver.NormaliseDomain(domain)] = t
+	}
+
+	return func(domain string) (Target, error) {
+		if t, ok := normalised[solver.NormaliseDomain(domain)]; ok {
+			return t, nil
+		}
+
+		if fallback == nil {
+			return Target{}, fmt.Errorf("no S3 bucket configured for %v", domain)
+		}
+
+		return fallback(domain)
+	}
+}
+
+// ParseBucketMap parses a comma separated list of domain=bucket[/prefix]
+// entries, e.g. "example.com=challenges/example,www.example.org=www.example.org"
+func ParseBucketMap(s string) (map[string]Target, error) {
+	targets := map[string]Target{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid bucket map entry %q, expected domain=bucket[/prefix]", entry)
+		}
+
+		t := Target{Bucket: parts[1]}
+		if i := strings.Index(parts[1], "/"); i >= 0 {
+			t = Target{Bucket: parts[1][:i], KeyPrefix: parts[1][i+1:]}
+		}
+
+		targets[parts[0]] = t
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,71 @@
+package s3
+
+import (
+	"testing"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+func TestDomainBucket(t *testing.T) {
+	target, err := DomainBucket("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, "www.example.com", target.Bucket)
+	helpers.ExpectStringMatch(t, ".well-known/acme-challenge/token", target.key("token"))
+}
+
+func TestSharedBucket(t *testing.T) {
+	target, err := SharedBucket("challenges", "acme/")("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, "challenges", target.Bucket)
+	helpers.ExpectStringMatch(t, "acme/.well-known/acme-challenge/token", target.key("token"))
+}
+
+func TestStaticMapper(t *testing.T) {
+	targets := map[string]Target{
+		"WWW.Example.com": {Bucket: "example"},
+	}
+
+	m := StaticMapper(targets, nil)
+
+	target, err := m("www.example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectStringMatch(t, "example", target.Bucket)
+
+	if _, err := m("www.other.com"); err == nil {
+		t.Errorf("Expected an error for an unmapped domain")
+	}
+
+	// Unmapped domains can fall back to another Mapper
+	target, err = StaticMapper(targets, DomainBucket)("www.other.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectStringMatch(t, "www.other.com", target.Bucket)
+}
+
+func TestParseBucketMap(t *testing.T) {
+	targets, err := ParseBucketMap("example.com=challenges/example, www.example.org=www.example.org,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectIntMatch(t, 2, len(targets))
+	helpers.ExpectStringMatch(t, "challenges", targets["example.com"].Bucket)
+	helpers.ExpectStringMatch(t, "example", targets["example.com"].KeyPrefix)
+	helpers.ExpectStringMatch(t, "www.example.org", targets["www.example.org"].Bucket)
+	helpers.ExpectStringMatch(t, "", targets["www.example.org"].KeyPrefix)
+
+	for _, s := range []string{"example.com", "=bucket", "example.com="} {
+		if _, err := ParseBucketMap(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	// DefaultReplicationTimeout is how long we wait for a challenge to reach
+	// every replica
+	DefaultReplicationTimeout = 2 * time.Minute
+	// DefaultReplicationInterval is how long we wait between HeadObject polls
+	DefaultReplicationInterval = time.Second
+)
+
+// Replica is another bucket that must hold the challenge, possibly in another
+// region. The client must be for the bucket's region.
+type Replica struct {
+	Client s3iface.S3API
+	Mapper Mapper
+}
+
+// ReplicationError is returned when a challenge hasn't reached every replica
+type ReplicationError struct {
+	Key     string
+	Buckets []string
+	Err     error
+}
+
+func (e *ReplicationError) Error() string {
+	msg := fmt.Sprintf("challenge %v has not replicated to bucket(s) %v", e.Key, strings.Join(e.Buckets, ", "))
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+func (e *ReplicationError) Unwrap() error {
+	return e.Err
+}
+
+// WithMirrors makes Present write the challenge to each replica directly, and
+// CleanUp delete it from them, rather than relying on S3 replication
+func (s *Solver) WithMirrors(replicas ...Replica) *Solver {
+	s.mirrors = replicas
+	return s
+}
+
+// WithReplicationCheck makes Present poll each replica with HeadObject until
+// S3 replication has copied the challenge to it. This replaces guessing a
+// delay with WithDelay. Replication keeps the object key, so only the bucket of
+// each replica's Target is used.
+func (s *Solver) WithReplicationCheck(replicas ...Replica) *Solver {
+	s.replicas = replicas
+	return s
+}
+
+// WithReplicationTimeout allows you to override DefaultReplicationTimeout
+func (s *Solver) WithReplicationTimeout(t time.Duration) *Solver {
+	s.replicationTimeout = t
+	return s
+}
+
+// putMirrors writes the challenge to every mirror
+func (s *Solver) putMirrors(ctx context.Context, domain, token, keyAuth string) error {
+	for _, m := range s.mirrors {
+		t, err := m.Mapper(domain)
+		if err != nil {
+			return err
+		}
+
+		if err := s.put(ctx, m.Client, t, token, keyAuth); err != nil {
+			return fmt.Errorf("writing challenge to bucket %v: %w", t.Bucket, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteMirrors removes the challenge from every mirror, carrying on past
+// failures so that as little as possible is left behind
+func (s *Solver) deleteMirrors(ctx context.Context, domain, token string) error {
+	var firstErr error
+	for _, m := range s.mirrors {
+		t, err := m.Mapper(domain)
+		if err == nil {
+			err = s.delete(ctx, m.Client, t, token)
+		}
+		if err != nil {
+			log.Printf("[ERROR] could not delete challenge from mirror %v: %v", t.Bucket, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("deleting challenge from bucket %v: %w", t.Bucket, err)
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// waitForReplicas polls the replicas until every one has the object. It fails
+// immediately if S3 reports that replication of the source object failed, or a
+// replica returns an error other than not found (or forbidden, which is how S3
+// reports a missing object to callers without s3:ListBucket).
+func (s *Solver) waitForReplicas(ctx context.Context, source Target, domain, token string) error {
+	if len(s.replicas) == 0 {
+		return nil
+	}
+
+	key := source.key(token)
+
+	// The targets that don't have the object yet
+	type replicaTarget struct {
+		client s3iface.S3API
+		target Target
+	}
+	var pending []replicaTarget
+	for _, r := range s.replicas {
+		t, err := r.Mapper(domain)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, replicaTarget{client: r.Client, target: t})
+	}
+
+	// laggingError names every bucket that doesn't have the object yet
+	laggingError := func(err error) error {
+		buckets := make([]string, 0, len(pending))
+		for _, r := range pending {
+			buckets = append(buckets, r.target.Bucket)
+		}
+
+		return &ReplicationError{Key: key, Buckets: buckets, Err: err}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.replicationTimeout)
+	defer cancel()
+
+	// Without s3:ListBucket, S3 reports a missing object as 403 rather than
+	// 404, so a 403 only becomes the error if the object never turns up
+	var forbidden error
+	timeoutError := func() error {
+		if forbidden != nil {
+			return laggingError(fmt.Errorf("%v (HeadObject needs s3:ListBucket to tell a missing object from a forbidden one): %w", ctx.Err(), forbidden))
+		}
+
+		return laggingError(ctx.Err())
+	}
+
+	for {
+		if err := s.checkSourceReplication(ctx, source, key); err != nil {
+			if ctx.Err() != nil {
+				return timeoutError()
+			}
+			return err
+		}
+
+		var lagging []replicaTarget
+		for _, r := range pending {
+			found, err := headObject(ctx, r.client, r.target.Bucket, key)
+			if ctx.Err() != nil {
+				return timeoutError()
+			}
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "Forbidden" {
+				forbidden, err = err, nil
+			}
+			if err != nil {
+				return &ReplicationError{Key: key, Buckets: []string{r.target.Bucket}, Err: err}
+			}
+			if !found {
+				lagging = append(lagging, r)
+			}
+		}
+		pending = lagging
+
+		if len(pending) == 0 {
+			log.Printf("[INFO] challenge %v has replicated to every bucket", key)
+			return nil
+		}
+
+		if err := sleep(ctx, s.replicationInterval); err != nil {
+			return timeoutError()
+		}
+	}
+}
+
+// checkSourceReplication fails if S3 has given up replicating the source object
+func (s *Solver) checkSourceReplication(ctx context.Context, source Target, key string) error {
+	out, err := s.s3Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(source.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("checking replication status of %v in bucket %v: %w", key, source.Bucket, err)
+	}
+
+	if aws.StringValue(out.ReplicationStatus) == s3.ReplicationStatusFailed {
+		return fmt.Errorf("S3 failed to replicate %v from bucket %v", key, source.Bucket)
+	}
+
+	return nil
+}
+
+// headObject reports whether an object exists
+func headObject(ctx context.Context, c s3iface.S3API, bucket, key string) (bool, error) {
+	_, err := c.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return true, nil
+	}
+
+	// HeadObject has no body, so a missing object is just a 404
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "NotFound", s3.ErrCodeNoSuchKey:
+			return false, nil
+		}
+	}
+
+	return false, err
+}
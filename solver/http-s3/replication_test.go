@@ -0,0 +1,134 @@
+package s3
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+const testKey = ".well-known/acme-challenge/token"
+
+func TestWithMirrors(t *testing.T) {
+	primary := newFakeS3()
+	mirror := newFakeS3()
+	s := New(primary).WithSelfCheck(nil).WithMirrors(Replica{Client: mirror, Mapper: SharedBucket("mirror", "")})
+
+	err := s.Present("www.example.com", "token", "keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, "keyauth", primary.objects["www.example.com/"+testKey])
+	helpers.ExpectStringMatch(t, "keyauth", mirror.objects["mirror/"+testKey])
+
+	err = s.CleanUp("www.example.com", "token", "keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 0, len(primary.objects))
+	helpers.ExpectIntMatch(t, 0, len(mirror.objects))
+}
+
+// testReplicationSolver returns a Solver that checks replication to a single
+// replica bucket
+func testReplicationSolver(primary, replica *fakeS3) *Solver {
+	s := New(primary).WithSelfCheck(nil).WithReplicationCheck(Replica{Client: replica, Mapper: SharedBucket("replica", "")})
+	s.WithReplicationTimeout(50 * time.Millisecond)
+	s.replicationInterval = time.Millisecond
+
+	return s
+}
+
+func TestWithReplicationCheck(t *testing.T) {
+	primary := newFakeS3()
+	replica := newFakeS3()
+
+	// The object turns up in the replica on the third poll
+	replica.onHead = func(heads int) {
+		if heads == 3 {
+			replica.objects["replica/"+testKey] = "keyauth"
+		}
+	}
+
+	err := testReplicationSolver(primary, replica).Present("www.example.com", "token", "keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 3, replica.heads)
+}
+
+func TestWithReplicationCheck_timeout(t *testing.T) {
+	err := testReplicationSolver(newFakeS3(), newFakeS3()).Present("www.example.com", "token", "keyauth")
+
+	var rerr *ReplicationError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("Expected a ReplicationError, got %v", err)
+	}
+	helpers.ExpectIntMatch(t, 1, len(rerr.Buckets))
+	helpers.ExpectStringMatch(t, "replica", rerr.Buckets[0])
+}
+
+func TestWithReplicationCheck_failFast(t *testing.T) {
+	// S3 has given up replicating the source object
+	primary := newFakeS3()
+	primary.replicationStatus = s3.ReplicationStatusFailed
+	replica := newFakeS3()
+
+	err := testReplicationSolver(primary, replica).Present("www.example.com", "token", "keyauth")
+	if err == nil {
+		t.Fatal("Expected an error when replication has failed")
+	}
+	helpers.ExpectIntMatch(t, 0, replica.heads)
+
+	// The replica returns something other than not found
+	replica = newFakeS3()
+	replica.headErr = awserr.New("PermanentRedirect", "The bucket is in another region", nil)
+
+	err = testReplicationSolver(newFakeS3(), replica).Present("www.example.com", "token", "keyauth")
+	var rerr *ReplicationError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("Expected a ReplicationError, got %v", err)
+	}
+	helpers.ExpectStringMatch(t, "replica", rerr.Buckets[0])
+	helpers.ExpectIntMatch(t, 1, replica.heads)
+}
+
+func TestWithReplicationCheck_forbidden(t *testing.T) {
+	// Without s3:ListBucket a missing object is a 403, so we keep polling
+	replica := newFakeS3()
+	replica.headErr = awserr.New("Forbidden", "Forbidden", nil)
+	replica.onHead = func(heads int) {
+		if heads == 3 {
+			replica.headErr = nil
+			replica.objects["replica/"+testKey] = "keyauth"
+		}
+	}
+
+	err := testReplicationSolver(newFakeS3(), replica).Present("www.example.com", "token", "keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 3, replica.heads)
+
+	// If it never turns up, the error says why
+	replica = newFakeS3()
+	replica.headErr = awserr.New("Forbidden", "Forbidden", nil)
+
+	err = testReplicationSolver(newFakeS3(), replica).Present("www.example.com", "token", "keyauth")
+	var rerr *ReplicationError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("Expected a ReplicationError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "s3:ListBucket") {
+		t.Errorf("Expected the error to mention s3:ListBucket, got %v", err)
+	}
+	if replica.heads < 2 {
+		t.Errorf("Expected the replica to be polled until the timeout, got %v polls", replica.heads)
+	}
+}
@@ -5,12 +5,14 @@
 // 3. remote CA requests the keyauth from the well known path in S3
 // 4. s3 presents the keyauth to the remote CA
 //
-// In order for s3 to route to your bucket using http, the bucket name will need to match
-// the domain name for which you are creating a certificate
+// By default the challenge is written to a bucket named after the domain, since the
+// S3 website endpoint routes requests for a domain to the bucket with the same
+// name. A Mapper can write it somewhere else instead, e.g. a shared bucket behind
+// CloudFront.
 package s3
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"strings"
 	"time"
@@ -18,21 +20,64 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/sjauld/acme-sls/solver/selfcheck"
 )
 
 // Solver implements lego's challenge.Provider
 type Solver struct {
+	ctx      context.Context
 	s3Client s3iface.S3API
 	delay    time.Duration
+	mapper   Mapper
+	acl      string
+
+	// Other buckets that are written directly, or that S3 replicates to
+	mirrors             []Replica
+	replicas            []Replica
+	replicationTimeout  time.Duration
+	replicationInterval time.Duration
+
+	// Check that the challenge is reachable before the CA is notified
+	selfCheck *selfcheck.Checker
 }
 
 // New returns a pointer to a Solver, initialised with an s3 client
 func New(client s3iface.S3API) *Solver {
 	return &Solver{
+		ctx:      context.Background(),
 		s3Client: client,
+		mapper:   DomainBucket,
+		acl:      s3.ObjectCannedACLPublicRead,
+
+		selfCheck: selfcheck.New(),
+
+		replicationTimeout:  DefaultReplicationTimeout,
+		replicationInterval: DefaultReplicationInterval,
 	}
 }
 
+// WithMapper allows you to choose the bucket and key prefix for each domain,
+// rather than using a bucket named after the domain
+func (s *Solver) WithMapper(m Mapper) *Solver {
+	s.mapper = m
+	return s
+}
+
+// WithACL sets the canned ACL applied to challenge objects. The default is
+// public-read, which the S3 website endpoint needs; pass an empty string to
+// rely on a bucket policy or CloudFront origin access control instead, e.g. when
+// S3 Block Public Access is enabled.
+func (s *Solver) WithACL(acl string) *Solver {
+	s.acl = acl
+	return s
+}
+
+// WithContext sets the context used by Present and CleanUp
+func (s *Solver) WithContext(ctx context.Context) *Solver {
+	s.ctx = ctx
+	return s
+}
+
 // WithDelay allows you to introduce a delay between uploading to S3 and
 // continuing with the validation process. This is helpful if you are doing
 // something with S3 replication, for example
@@ -41,42 +86,112 @@ func (s *Solver) WithDelay(t time.Duration) *Solver {
 	return s
 }
 
+// WithSelfCheck overrides the check that Present uses to wait until the keyauth
+// is served, e.g. to change the timeout. Pass nil to notify the CA as soon as
+// the challenge is written. The check runs after any delay.
+func (s *Solver) WithSelfCheck(c *selfcheck.Checker) *Solver {
+	s.selfCheck = c
+	return s
+}
+
 // Present writes the challenge information into S3 so that we
 // can respond to HTTP queries with the correct value
 func (s *Solver) Present(domain, token, keyAuth string) error {
+	return s.PresentWithContext(s.ctx, domain, token, keyAuth)
+}
+
+// PresentWithContext is the same as Present with the addition of the ability
+// to pass a context
+func (s *Solver) PresentWithContext(ctx context.Context, domain, token, keyAuth string) error {
 	log.Printf("[INFO] Presenting domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
 
-	in := &s3.PutObjectInput{
-		Bucket: aws.String(domain),
-		ACL:    aws.String(s3.ObjectCannedACLPublicRead),
-		Body:   strings.NewReader(keyAuth),
-		Key:    keyFromToken(token),
+	t, err := s.mapper(domain)
+	if err != nil {
+		return err
 	}
 
-	_, err := s.s3Client.PutObject(in)
-	if err != nil {
+	if err := s.put(ctx, s.s3Client, t, token, keyAuth); err != nil {
+		return err
+	}
+
+	if err := s.putMirrors(ctx, domain, token, keyAuth); err != nil {
 		return err
 	}
 
-	time.Sleep(s.delay)
-	return nil
+	if err := s.waitForReplicas(ctx, t, domain, token); err != nil {
+		return err
+	}
+
+	if err := sleep(ctx, s.delay); err != nil {
+		return err
+	}
+
+	if s.selfCheck == nil {
+		return nil
+	}
+
+	return s.selfCheck.HTTP01(ctx, domain, token, keyAuth)
 }
 
 // CleanUp removes the challenge information from S3
 func (s *Solver) CleanUp(domain, token, keyAuth string) error {
+	return s.CleanUpWithContext(s.ctx, domain, token, keyAuth)
+}
+
+// CleanUpWithContext is the same as CleanUp with the addition of the ability
+// to pass a context
+func (s *Solver) CleanUpWithContext(ctx context.Context, domain, token, keyAuth string) error {
 	log.Printf("[INFO] CleaningUp domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
 
+	t, err := s.mapper(domain)
+	if err != nil {
+		return err
+	}
+
+	err = s.delete(ctx, s.s3Client, t, token)
+	if mirrorErr := s.deleteMirrors(ctx, domain, token); err == nil {
+		err = mirrorErr
+	}
+
+	return err
+}
+
+// put writes the keyauth to the target
+func (s *Solver) put(ctx context.Context, c s3iface.S3API, t Target, token, keyAuth string) error {
+	in := &s3.PutObjectInput{
+		Bucket:      aws.String(t.Bucket),
+		Body:        strings.NewReader(keyAuth),
+		ContentType: aws.String("text/plain"),
+		Key:         aws.String(t.key(token)),
+	}
+	if s.acl != "" {
+		in.ACL = aws.String(s.acl)
+	}
+
+	_, err := c.PutObjectWithContext(ctx, in)
+	return err
+}
+
+// delete removes the keyauth from the target
+func (s *Solver) delete(ctx context.Context, c s3iface.S3API, t Target, token string) error {
 	in := &s3.DeleteObjectInput{
-		Bucket: aws.String(domain),
-		Key:    keyFromToken(token),
+		Bucket: aws.String(t.Bucket),
+		Key:    aws.String(t.key(token)),
 	}
 
-	_, err := s.s3Client.DeleteObject(in)
+	_, err := c.DeleteObjectWithContext(ctx, in)
 	return err
 }
 
-// The request from ACME will be to http://<domain>/.well-known/acme-challenge/<token>
-func keyFromToken(t string) *string {
-	k := fmt.Sprintf(".well-known/acme-challenge/%s", t)
-	return &k
+// sleep waits for the duration, or until the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
 }
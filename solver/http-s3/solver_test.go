@@ -0,0 +1,114 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// fakeS3 keeps the objects and ACLs written to it, keyed by bucket/key
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string]string
+	acls    map[string]string
+
+	// HeadObject behaviour
+	heads             int
+	headErr           error
+	replicationStatus string
+	onHead            func(heads int)
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects: map[string]string{},
+		acls:    map[string]string{},
+	}
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	k := aws.StringValue(in.Bucket) + "/" + aws.StringValue(in.Key)
+	f.objects[k] = string(b)
+	f.acls[k] = aws.StringValue(in.ACL)
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObjectWithContext(ctx aws.Context, in *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	delete(f.objects, aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key))
+
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) HeadObjectWithContext(ctx aws.Context, in *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	f.heads++
+	if f.onHead != nil {
+		f.onHead(f.heads)
+	}
+	if f.headErr != nil {
+		return nil, f.headErr
+	}
+
+	if _, ok := f.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)]; !ok {
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+
+	return &s3.HeadObjectOutput{ReplicationStatus: aws.String(f.replicationStatus)}, nil
+}
+
+func TestPresentCleanUp(t *testing.T) {
+	f := newFakeS3()
+	s := New(f).WithSelfCheck(nil)
+
+	err := s.Present("www.example.com", "token", "keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := "www.example.com/.well-known/acme-challenge/token"
+	helpers.ExpectStringMatch(t, "keyauth", f.objects[k])
+	helpers.ExpectStringMatch(t, s3.ObjectCannedACLPublicRead, f.acls[k])
+
+	err = s.CleanUp("www.example.com", "token", "keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectIntMatch(t, 0, len(f.objects))
+}
+
+func TestPresent_sharedBucketWithoutACL(t *testing.T) {
+	f := newFakeS3()
+	s := New(f).WithSelfCheck(nil).WithMapper(SharedBucket("challenges", "acme")).WithACL("")
+
+	err := s.PresentWithContext(context.Background(), "www.example.com", "token", "keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := "challenges/acme/.well-known/acme-challenge/token"
+	helpers.ExpectStringMatch(t, "keyauth", f.objects[k])
+	helpers.ExpectStringMatch(t, "", f.acls[k])
+}
+
+func TestPresent_unmappedDomain(t *testing.T) {
+	f := newFakeS3()
+	s := New(f).WithSelfCheck(nil).WithMapper(StaticMapper(map[string]Target{}, nil))
+
+	if err := s.Present("www.example.com", "token", "keyauth"); err == nil {
+		t.Errorf("Expected an error for an unmapped domain")
+	}
+	helpers.ExpectIntMatch(t, 0, len(f.objects))
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Store errors are classified into one of the following kinds. Use errors.Is to
+// check which kind of error a Store returned, e.g.
+//
+//	if errors.Is(err, ErrStoreNotFound) { ... }
+var (
+	// ErrStoreNotFound means the challenge does not exist (or has expired)
+	ErrStoreNotFound = errors.New("Challenge not found in the store")
+	// ErrStoreRateLimited means the backend is throttling us
+	ErrStoreRateLimited = errors.New("We were rate limited, try again later")
+	// ErrStoreTableNotFound means the table or bucket backing the store does not
+	// exist, which is a configuration problem rather than a missing challenge
+	ErrStoreTableNotFound = errors.New("The store's table or bucket does not exist")
+)
+
+// StoreError is returned by the Store implementations in this package. It
+// records the operation that failed, the kind of failure (one of the ErrStore
+// variables, or nil if it could not be classified) and the underlying error from
+// the backend.
+type StoreError struct {
+	Op   string
+	Kind error
+	Err  error
+}
+
+func (e *StoreError) Error() string {
+	switch {
+	case e.Kind == nil:
+		return fmt.Sprintf("%s: %v", e.Op, e.Err)
+	case e.Err == nil:
+		return fmt.Sprintf("%s: %v", e.Op, e.Kind)
+	default:
+		return fmt.Sprintf("%s: %v: %v", e.Op, e.Kind, e.Err)
+	}
+}
+
+// Unwrap returns the underlying error from the backend
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of the target kind
+func (e *StoreError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// newStoreError wraps err in a StoreError, returning nil if err is nil
+func newStoreError(op string, kind, err error) error {
+	if kind == nil && err == nil {
+		return nil
+	}
+
+	return &StoreError{
+		Op:   op,
+		Kind: kind,
+		Err:  err,
+	}
+}
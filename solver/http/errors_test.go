@@ -0,0 +1,45 @@
+package http
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+func TestStoreError(t *testing.T) {
+	cause := errors.New("cause")
+	err := newStoreError("GetChallenge", ErrStoreRateLimited, cause)
+
+	if !errors.Is(err, ErrStoreRateLimited) {
+		t.Errorf("Expected error to be %v", ErrStoreRateLimited)
+	}
+	if errors.Is(err, ErrStoreNotFound) {
+		t.Errorf("Expected error not to be %v", ErrStoreNotFound)
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("Expected error to wrap %v", cause)
+	}
+
+	var serr *StoreError
+	if !errors.As(err, &serr) {
+		t.Fatal("Expected a StoreError")
+	}
+	helpers.ExpectStringMatch(t, "GetChallenge", serr.Op)
+	helpers.ExpectStringMatch(t, "GetChallenge: We were rate limited, try again later: cause", err.Error())
+}
+
+func TestStoreError_unclassified(t *testing.T) {
+	err := newStoreError("PutChallenge", nil, errors.New("boom"))
+
+	for _, kind := range []error{ErrStoreNotFound, ErrStoreRateLimited, ErrStoreTableNotFound} {
+		if errors.Is(err, kind) {
+			t.Errorf("Expected error not to be %v", kind)
+		}
+	}
+	helpers.ExpectStringMatch(t, "PutChallenge: boom", err.Error())
+
+	if newStoreError("PutChallenge", nil, nil) != nil {
+		t.Error("Expected a nil error")
+	}
+}
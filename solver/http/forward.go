@@ -0,0 +1,110 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// ForwardedHeader is set on requests forwarded to an upstream responder. A
+	// Responder never forwards a request that already carries it, so two nodes
+	// pointing at each other can't loop.
+	ForwardedHeader = "X-Acme-Sls-Forwarded"
+
+	// ChallengeDomainHeader is set on successful challenge responses so that a
+	// forwarding Responder can check the domain the challenge was stored for
+	ChallengeDomainHeader = "X-Acme-Sls-Challenge-Domain"
+
+	// DefaultForwardTimeout is used when WithUpstream is given a zero timeout
+	DefaultForwardTimeout = 5 * time.Second
+
+	// maxKeyAuthLength limits how much of the upstream response we'll read. A
+	// keyauth is a token and a base64 encoded SHA-256 thumbprint.
+	maxKeyAuthLength = 1024
+)
+
+// WithUpstream configures the Responder to forward challenge requests to another
+// responder when the challenge is not in the Store. This is useful when the CA can
+// reach nodes that can't reach the Store. The original Host header is preserved,
+// and the upstream keyauth is only served if it is for the requested token and
+// passes the same host validation as a challenge from the Store. The upstream
+// must be a Responder from this package, as it has to name the challenge's
+// domain in the ChallengeDomainHeader.
+func (r *Responder) WithUpstream(upstream *url.URL, timeout time.Duration) *Responder {
+	if timeout == 0 {
+		timeout = DefaultForwardTimeout
+	}
+
+	r.upstream = upstream
+	r.client = &http.Client{
+		Timeout: timeout,
+		// The CA follows redirects, but we shouldn't be sending it somewhere else
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return r
+}
+
+// canForward checks whether a request may be forwarded to the upstream responder
+func (r *Responder) canForward(req *http.Request) bool {
+	return r.upstream != nil && req.Header.Get(ForwardedHeader) == ""
+}
+
+// forward requests the challenge from the upstream responder
+func (r *Responder) forward(req *http.Request, token string) (*Challenge, error) {
+	u := *r.upstream
+	u.Path = path.Join("/", r.upstream.Path, ChallengePathPrefix, token)
+	u.RawQuery = ""
+
+	out, err := http.NewRequestWithContext(req.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, newStoreError("Forward", nil, err)
+	}
+	out.Host = req.Host
+	out.Header.Set(ForwardedHeader, "1")
+	out.Header.Set("X-Forwarded-Host", req.Host)
+
+	resp, err := r.client.Do(out)
+	if err != nil {
+		return nil, newStoreError("Forward", nil, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, newStoreError("Forward", ErrStoreNotFound, nil)
+	case http.StatusTooManyRequests:
+		return nil, newStoreError("Forward", ErrStoreRateLimited, nil)
+	default:
+		return nil, newStoreError("Forward", nil, fmt.Errorf("upstream responded with %v", resp.Status))
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxKeyAuthLength))
+	if err != nil {
+		return nil, newStoreError("Forward", nil, err)
+	}
+	keyAuth := strings.TrimSpace(string(b))
+
+	// A keyauth is always <token>.<thumbprint>, anything else isn't a challenge
+	if !strings.HasPrefix(keyAuth, token+".") {
+		return nil, newStoreError("Forward", ErrStoreNotFound, fmt.Errorf("upstream response is not a keyauth for %v", token))
+	}
+
+	// Responders in this package tell us which domain the challenge was for.
+	// Without that we can't check the host, so it isn't a challenge we can serve.
+	domain := resp.Header.Get(ChallengeDomainHeader)
+	if domain == "" {
+		return nil, newStoreError("Forward", ErrStoreNotFound, fmt.Errorf("upstream response has no %v header", ChallengeDomainHeader))
+	}
+
+	return NewChallenge(domain, token, keyAuth), nil
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+func testUpstream(t *testing.T, h http.Handler) *url.URL {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+func TestResponderWithUpstream(t *testing.T) {
+	upstreamStore, _ := testRedisStore(t)
+	err := upstreamStore.PutChallenge(NewChallenge("www.forward.com", "fwdtoken", "fwdtoken.thumbprint"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := testUpstream(t, NewHandler(upstreamStore, nil))
+
+	store, _ := testRedisStore(t)
+	h := NewResponder(store).WithUpstream(upstream, 0).Handler(nil)
+
+	tests := []struct {
+		url       string
+		forwarded bool
+		code      int
+		body      string
+	}{
+		{"http://www.forward.com/.well-known/acme-challenge/fwdtoken", false, http.StatusOK, "fwdtoken.thumbprint"},
+		{"http://www.forward.com:80/.well-known/acme-challenge/fwdtoken", false, http.StatusOK, "fwdtoken.thumbprint"},
+		{"http://www.other.com/.well-known/acme-challenge/fwdtoken", false, http.StatusNotFound, "Challenge not found"},
+		{"http://www.forward.com/.well-known/acme-challenge/missing", false, http.StatusNotFound, "Challenge not found"},
+		{"http://www.forward.com/.well-known/acme-challenge/fwdtoken", true, http.StatusNotFound, "Challenge not found"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.forwarded {
+			req.Header.Set(ForwardedHeader, "1")
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		helpers.ExpectIntMatch(t, tt.code, w.Code)
+		helpers.ExpectStringMatch(t, tt.body, w.Body.String())
+	}
+}
+
+func TestResponderWithUpstream_validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		domain  string
+		keyAuth string
+		code    int
+	}{
+		{"valid", "www.forward.com", "token.thumbprint", http.StatusOK},
+		{"no domain header", "", "token.thumbprint", http.StatusNotFound},
+		{"wrong domain", "www.other.com", "token.thumbprint", http.StatusNotFound},
+		{"not a keyauth", "www.forward.com", "<html>hello</html>", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		var gotHost, gotForwarded string
+		upstream := testUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			gotHost = req.Host
+			gotForwarded = req.Header.Get(ForwardedHeader)
+			if tt.domain != "" {
+				w.Header().Set(ChallengeDomainHeader, tt.domain)
+			}
+			w.Write([]byte(tt.keyAuth))
+		}))
+
+		store, _ := testRedisStore(t)
+		h := NewResponder(store).WithUpstream(upstream, 0).Handler(nil)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://www.forward.com/.well-known/acme-challenge/token", nil))
+
+		if w.Code != tt.code {
+			t.Errorf("%v: expected %d, got %d", tt.name, tt.code, w.Code)
+		}
+		if gotHost != "www.forward.com" {
+			t.Errorf("%v: expected the host to be preserved, got %v", tt.name, gotHost)
+		}
+		if gotForwarded == "" {
+			t.Errorf("%v: expected the %v header to be set", tt.name, ForwardedHeader)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultHealthCheckInterval is how long a HealthCheck result is cached for
+const DefaultHealthCheckInterval = 10 * time.Second
+
+// DefaultHealthCheckTimeout is how long the round trip against the Store can
+// take before the Store is reported unhealthy
+const DefaultHealthCheckTimeout = 5 * time.Second
+
+// healthCheckDomain is used for the canary challenge; .invalid can never be a
+// real domain (RFC 2606)
+const healthCheckDomain = "health.acme-sls.invalid"
+
+// HealthCheck verifies that a Store is usable by writing, reading and deleting a
+// canary challenge. The Store credentials therefore need write access, not just
+// the read access required to answer challenges.
+type HealthCheck struct {
+	store    Store
+	interval time.Duration
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	last *HealthResult
+	// running is closed when the round trip in progress finishes
+	running chan struct{}
+	now     func() time.Time
+}
+
+// HealthResult is the outcome of a HealthCheck
+type HealthResult struct {
+	Healthy bool `json:"healthy"`
+	// Op is the Store operation that failed
+	Op string `json:"op,omitempty"`
+	// ErrorClass classifies the failure, e.g. table_not_found or rate_limited
+	ErrorClass string `json:"errorClass,omitempty"`
+	// Err is the failure itself. It isn't served, as it names the table or
+	// bucket.
+	Err       error     `json:"-"`
+	LatencyMS float64   `json:"latencyMs"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// NewHealthCheck returns a pointer to a HealthCheck for the Store. Results are
+// cached for interval so that a busy load balancer doesn't hammer the Store.
+func NewHealthCheck(store Store, interval time.Duration) *HealthCheck {
+	return &HealthCheck{
+		store:    store,
+		interval: interval,
+		timeout:  DefaultHealthCheckTimeout,
+		now:      time.Now,
+	}
+}
+
+// WithTimeout allows you to override DefaultHealthCheckTimeout
+func (hc *HealthCheck) WithTimeout(timeout time.Duration) *HealthCheck {
+	hc.timeout = timeout
+	return hc
+}
+
+// Check returns the cached result, or performs a round trip against the Store if
+// the cached result is too old. Concurrent callers share the round trip, which
+// runs on its own context so that a caller going away doesn't fail (and cache)
+// the result for everyone else; ctx only limits how long this caller waits.
+func (hc *HealthCheck) Check(ctx context.Context) HealthResult {
+	hc.mu.Lock()
+	if hc.last != nil && hc.now().Sub(hc.last.CheckedAt) < hc.interval {
+		defer hc.mu.Unlock()
+		return *hc.last
+	}
+	if hc.running == nil {
+		hc.running = make(chan struct{})
+		go hc.run(hc.running)
+	}
+	running := hc.running
+	hc.mu.Unlock()
+
+	select {
+	case <-running:
+	case <-ctx.Done():
+		return HealthResult{Op: "Wait", ErrorClass: outcomeError, Err: ctx.Err(), CheckedAt: hc.now()}
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	return *hc.last
+}
+
+// run performs a round trip and caches the result, closing done afterwards
+func (hc *HealthCheck) run(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.timeout)
+	defer cancel()
+
+	start := hc.now()
+	op, err := hc.roundTrip(ctx)
+
+	res := &HealthResult{
+		Healthy:   err == nil,
+		LatencyMS: float64(hc.now().Sub(start)) / float64(time.Millisecond),
+		CheckedAt: start,
+	}
+	if err != nil {
+		res.Op = op
+		res.ErrorClass = errorOutcome(err)
+		res.Err = err
+		log.Printf("[ERROR] health check %v failed: %v", op, err)
+	}
+
+	hc.mu.Lock()
+	hc.last = res
+	hc.running = nil
+	hc.mu.Unlock()
+	close(done)
+}
+
+// roundTrip puts, gets and deletes a canary challenge, returning the failed
+// operation if there is an error
+func (hc *HealthCheck) roundTrip(ctx context.Context) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "Setup", err
+	}
+	token := "acme-sls-health-" + hex.EncodeToString(b)
+	ch := NewChallenge(healthCheckDomain, token, token+".canary")
+
+	if err := hc.store.PutChallengeWithContext(ctx, ch); err != nil {
+		return "PutChallenge", err
+	}
+
+	got, err := hc.store.GetChallengeWithContext(ctx, token)
+	if err == nil && got.KeyAuth != ch.KeyAuth {
+		err = fmt.Errorf("expected keyauth %v, got %v", ch.KeyAuth, got.KeyAuth)
+	}
+	if err != nil {
+		// Try not to leave the canary behind
+		hc.store.DeleteChallengeWithContext(ctx, token)
+		return "GetChallenge", err
+	}
+
+	if err := hc.store.DeleteChallengeWithContext(ctx, token); err != nil {
+		return "DeleteChallenge", err
+	}
+
+	return "", nil
+}
+
+// ServeHTTP implements http.Handler, responding with the HealthResult as JSON and
+// a 503 status if the Store is unhealthy
+func (hc *HealthCheck) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	res := hc.Check(req.Context())
+
+	code := http.StatusOK
+	if !res.Healthy {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(res)
+}
+
+// GinHandlerFunc returns a gin.HandlerFunc serving the HealthResult
+func (hc *HealthCheck) GinHandlerFunc() func(*gin.Context) {
+	return gin.WrapH(hc)
+}
@@ -0,0 +1,118 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+func TestHealthCheck(t *testing.T) {
+	store, mr := testRedisStore(t)
+
+	now := testNow
+	hc := NewHealthCheck(store, time.Minute)
+	hc.now = func() time.Time { return now }
+
+	res := hc.Check(context.Background())
+	if !res.Healthy {
+		t.Fatalf("Expected a healthy result, got %+v", res)
+	}
+	if len(mr.Keys()) != 0 {
+		t.Errorf("Expected the canary to be deleted, found %v", mr.Keys())
+	}
+
+	// The result is cached
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	res = hc.Check(context.Background())
+	if !res.Healthy {
+		t.Error("Expected the cached result to be healthy")
+	}
+
+	now = now.Add(time.Minute)
+	res = hc.Check(context.Background())
+	if res.Healthy {
+		t.Fatal("Expected an unhealthy result")
+	}
+	helpers.ExpectStringMatch(t, "PutChallenge", res.Op)
+	helpers.ExpectStringMatch(t, outcomeRateLimited, res.ErrorClass)
+}
+
+func TestHealthCheckServeHTTP(t *testing.T) {
+	hc := NewHealthCheck(&errStore{err: newStoreError("PutChallenge", ErrStoreTableNotFound, errors.New("no table"))}, time.Minute)
+
+	w := httptest.NewRecorder()
+	hc.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	helpers.ExpectIntMatch(t, http.StatusServiceUnavailable, w.Code)
+	helpers.ExpectStringMatch(t, "application/json", w.Header().Get("Content-Type"))
+
+	var res HealthResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectStringMatch(t, outcomeTableNotFound, res.ErrorClass)
+
+	// The error itself names the table, so it's only logged
+	if strings.Contains(w.Body.String(), "no table") {
+		t.Errorf("The response leaks the store error: %v", w.Body.String())
+	}
+}
+
+// slowStore is a Store whose PutChallenge blocks until release is closed, or
+// its context is done
+type slowStore struct {
+	errStore
+	release chan struct{}
+}
+
+func (s *slowStore) PutChallengeWithContext(ctx context.Context, ch *Challenge) error {
+	select {
+	case <-s.release:
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestHealthCheck_callerCancelled(t *testing.T) {
+	store := &slowStore{
+		errStore: errStore{err: newStoreError("PutChallenge", ErrStoreRateLimited, errors.New("throttled"))},
+		release:  make(chan struct{}),
+	}
+	hc := NewHealthCheck(store, time.Minute)
+
+	// The caller goes away mid check
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res := hc.Check(ctx); res.Healthy {
+		t.Errorf("Expected a cancelled caller to get an unhealthy result")
+	}
+
+	// The check carries on, and its result is the one that's cached
+	close(store.release)
+	res := hc.Check(context.Background())
+	helpers.ExpectStringMatch(t, outcomeRateLimited, res.ErrorClass)
+	res = hc.Check(context.Background())
+	helpers.ExpectStringMatch(t, outcomeRateLimited, res.ErrorClass)
+}
+
+func TestHealthCheck_timeout(t *testing.T) {
+	store := &slowStore{release: make(chan struct{})}
+	hc := NewHealthCheck(store, time.Minute).WithTimeout(10 * time.Millisecond)
+
+	res := hc.Check(context.Background())
+	if res.Healthy {
+		t.Fatal("Expected a slow store to be unhealthy")
+	}
+	helpers.ExpectStringMatch(t, "PutChallenge", res.Op)
+	if !errors.Is(res.Err, context.DeadlineExceeded) {
+		t.Errorf("Expected a deadline exceeded error, got %v", res.Err)
+	}
+}
@@ -0,0 +1,88 @@
+package http
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// hostProfile converts hostnames to their ASCII (punycode) form. Underscores are
+// allowed since they crop up in real world hostnames even though they are not
+// strictly valid.
+var hostProfile = idna.New(
+	idna.MapForLookup(),
+	idna.StrictDomainName(false),
+	idna.Transitional(false),
+)
+
+// wildcardPrefix marks a wildcard domain, e.g. *.example.com
+const wildcardPrefix = "*."
+
+// NormaliseHost converts a hostname, optionally including a port, into the form
+// used to compare the host of a request with the domain of a Challenge:
+//
+//   - any port is removed
+//   - IP addresses are returned in their canonical form, without brackets
+//   - names are lower-cased, stripped of a trailing dot and converted to ASCII
+//     (punycode) if they are internationalised
+//
+// The wildcard prefix of a domain like *.example.com is preserved.
+func NormaliseHost(h string) (string, error) {
+	h = strings.TrimSpace(h)
+
+	if host, _, err := net.SplitHostPort(h); err == nil {
+		h = host
+	} else if strings.HasPrefix(h, "[") && strings.HasSuffix(h, "]") {
+		// bracketed IPv6 literal without a port
+		h = h[1 : len(h)-1]
+	}
+
+	if ip := net.ParseIP(h); ip != nil {
+		return ip.String(), nil
+	}
+
+	h = strings.TrimSuffix(h, ".")
+
+	wildcard := strings.HasPrefix(h, wildcardPrefix)
+	h = strings.TrimPrefix(h, wildcardPrefix)
+
+	h, err := hostProfile.ToASCII(h)
+	if err != nil {
+		return "", err
+	}
+
+	if wildcard {
+		h = wildcardPrefix + h
+	}
+
+	return h, nil
+}
+
+// MatchHost reports whether the host of a request matches the domain a challenge
+// was issued for. Both are normalised with NormaliseHost first. The CA validates
+// the authorization for a wildcard domain like *.example.com at example.com, so
+// a wildcard domain matches its base domain.
+func MatchHost(reqHost, domain string) bool {
+	reqHost, err := NormaliseHost(reqHost)
+	if err != nil || reqHost == "" {
+		return false
+	}
+
+	domain, err = NormaliseHost(domain)
+	if err != nil || domain == "" {
+		return false
+	}
+
+	return reqHost == strings.TrimPrefix(domain, wildcardPrefix)
+}
+
+// NormaliseDomain is NormaliseHost for values we'd rather keep than reject,
+// e.g. the keys of a map of domains
+func NormaliseDomain(domain string) string {
+	if h, err := NormaliseHost(domain); err == nil {
+		return h
+	}
+
+	return strings.ToLower(domain)
+}
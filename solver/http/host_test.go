@@ -0,0 +1,77 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+func TestNormaliseHost(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"www.example.com", "www.example.com"},
+		{"WWW.Example.COM", "www.example.com"},
+		{"www.example.com.", "www.example.com"},
+		{"www.example.com:80", "www.example.com"},
+		{"www.example.com.:8080", "www.example.com"},
+		{" www.example.com ", "www.example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"BÜCHER.example", "xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"under_score.example.com", "under_score.example.com"},
+		{"*.Example.com", "*.example.com"},
+		{"192.0.2.1", "192.0.2.1"},
+		{"192.0.2.1:80", "192.0.2.1"},
+		{"[2001:db8::1]:80", "2001:db8::1"},
+		{"[2001:DB8::1]", "2001:db8::1"},
+		{"2001:db8:0:0::1", "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		out, err := NormaliseHost(tt.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.in, err)
+			continue
+		}
+		helpers.ExpectStringMatch(t, tt.out, out)
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		reqHost string
+		domain  string
+		match   bool
+	}{
+		{"www.example.com", "www.example.com", true},
+		{"www.example.com:80", "www.example.com", true},
+		{"WWW.EXAMPLE.COM", "www.example.com", true},
+		{"www.example.com.", "www.example.com", true},
+		{"www.example.com", "www.example.com.", true},
+		{"xn--bcher-kva.example", "bücher.example", true},
+		{"bücher.example", "xn--bcher-kva.example", true},
+		{"[2001:db8::1]:80", "2001:db8::1", true},
+		{"192.0.2.1:80", "192.0.2.1", true},
+		{"example.com", "*.example.com", true},
+		{"www.example.com", "*.example.com", false},
+		{"www.example.com", "example.com", false},
+		{"example.com", "www.example.com", false},
+		{"www.example.com.evil.com", "www.example.com", false},
+		{"", "www.example.com", false},
+		{"www.example.com", "", false},
+	}
+
+	for _, tt := range tests {
+		if MatchHost(tt.reqHost, tt.domain) != tt.match {
+			t.Errorf("MatchHost(%q, %q): expected %v", tt.reqHost, tt.domain, tt.match)
+		}
+	}
+}
+
+func TestNewChallenge_normalisesDomain(t *testing.T) {
+	ch := NewChallenge("WWW.Example.com.", "token", "keyauth")
+
+	helpers.ExpectStringMatch(t, "www.example.com", ch.Domain)
+}
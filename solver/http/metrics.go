@@ -0,0 +1,122 @@
+package http
+
+import (
+	"context"
+	"expvar"
+	"time"
+)
+
+// Metrics receives measurements of challenge traffic from a Responder, a
+// RateLimiter and any Store wrapped with InstrumentStore. The default
+// implementation publishes them via expvar; see the prommetrics package for a
+// Prometheus implementation.
+type Metrics interface {
+	// ObserveLookup counts a challenge request by outcome
+	ObserveLookup(outcome string)
+	// ObserveStoreRequest records the duration of a Store operation by outcome
+	ObserveStoreRequest(op, outcome string, d time.Duration)
+	// InFlight adjusts the number of challenge requests being served
+	InFlight(delta int)
+}
+
+// Outcome of a Store operation that succeeded
+const outcomeOK = "ok"
+
+var (
+	// storeRequests counts Store operations by op and outcome
+	storeRequests = expvar.NewMap("acme_sls_store_requests")
+	// storeRequestSeconds sums the duration of Store operations by op and outcome
+	storeRequestSeconds = expvar.NewMap("acme_sls_store_request_seconds")
+	// inFlight is the number of challenge requests being served
+	inFlight = expvar.NewInt("acme_sls_challenge_requests_in_flight")
+)
+
+// ExpvarMetrics is the default Metrics implementation. Everything is published
+// via expvar, which none of the servers in this repo expose; mount
+// expvar.Handler in your own server to read it.
+type ExpvarMetrics struct{}
+
+// ObserveLookup implements Metrics
+func (ExpvarMetrics) ObserveLookup(outcome string) {
+	lookups.Add(outcome, 1)
+}
+
+// ObserveStoreRequest implements Metrics
+func (ExpvarMetrics) ObserveStoreRequest(op, outcome string, d time.Duration) {
+	storeRequests.Add(op+":"+outcome, 1)
+	storeRequestSeconds.AddFloat(op+":"+outcome, d.Seconds())
+}
+
+// InFlight implements Metrics
+func (ExpvarMetrics) InFlight(delta int) {
+	inFlight.Add(int64(delta))
+}
+
+// instrumentedStore times each operation of the Store it wraps
+type instrumentedStore struct {
+	store   Store
+	metrics Metrics
+	now     func() time.Time
+}
+
+// InstrumentStore wraps a Store so that the duration and outcome of every
+// operation is recorded with m
+func InstrumentStore(store Store, m Metrics) Store {
+	return &instrumentedStore{
+		store:   store,
+		metrics: m,
+		now:     time.Now,
+	}
+}
+
+// observe records an operation that started at start
+func (is *instrumentedStore) observe(op string, start time.Time, err error) {
+	outcome := outcomeOK
+	if err != nil {
+		outcome = errorOutcome(err)
+	}
+
+	is.metrics.ObserveStoreRequest(op, outcome, is.now().Sub(start))
+}
+
+// DeleteChallenge implements Store
+func (is *instrumentedStore) DeleteChallenge(token string) error {
+	return is.DeleteChallengeWithContext(context.Background(), token)
+}
+
+// DeleteChallengeWithContext implements Store
+func (is *instrumentedStore) DeleteChallengeWithContext(ctx context.Context, token string) error {
+	start := is.now()
+	err := is.store.DeleteChallengeWithContext(ctx, token)
+	is.observe("DeleteChallenge", start, err)
+
+	return err
+}
+
+// GetChallenge implements Store
+func (is *instrumentedStore) GetChallenge(token string) (*Challenge, error) {
+	return is.GetChallengeWithContext(context.Background(), token)
+}
+
+// GetChallengeWithContext implements Store
+func (is *instrumentedStore) GetChallengeWithContext(ctx context.Context, token string) (*Challenge, error) {
+	start := is.now()
+	ch, err := is.store.GetChallengeWithContext(ctx, token)
+	is.observe("GetChallenge", start, err)
+
+	return ch, err
+}
+
+// PutChallenge implements Store
+func (is *instrumentedStore) PutChallenge(ch *Challenge) error {
+	return is.PutChallengeWithContext(context.Background(), ch)
+}
+
+// PutChallengeWithContext implements Store
+func (is *instrumentedStore) PutChallengeWithContext(ctx context.Context, ch *Challenge) error {
+	start := is.now()
+	err := is.store.PutChallengeWithContext(ctx, ch)
+	is.observe("PutChallenge", start, err)
+
+	return err
+}
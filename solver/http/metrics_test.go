@@ -0,0 +1,103 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// recordingMetrics keeps everything it observes
+type recordingMetrics struct {
+	lookups       map[string]int
+	storeRequests map[string]time.Duration
+	inFlight      int
+	maxInFlight   int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		lookups:       map[string]int{},
+		storeRequests: map[string]time.Duration{},
+	}
+}
+
+func (rm *recordingMetrics) ObserveLookup(outcome string) {
+	rm.lookups[outcome]++
+}
+
+func (rm *recordingMetrics) ObserveStoreRequest(op, outcome string, d time.Duration) {
+	rm.storeRequests[op+":"+outcome] += d
+}
+
+func (rm *recordingMetrics) InFlight(delta int) {
+	rm.inFlight += delta
+	if rm.inFlight > rm.maxInFlight {
+		rm.maxInFlight = rm.inFlight
+	}
+}
+
+func TestInstrumentStore(t *testing.T) {
+	redisStore, _ := testRedisStore(t)
+	m := newRecordingMetrics()
+
+	store := InstrumentStore(redisStore, m).(*instrumentedStore)
+	now := testNow
+	store.now = func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}
+
+	err := store.PutChallenge(NewChallenge("www.com", "token", "keyauth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.GetChallenge("token")
+	store.GetChallenge("missing")
+	store.DeleteChallenge("token")
+
+	for _, key := range []string{"PutChallenge:ok", "GetChallenge:ok", "GetChallenge:not_found", "DeleteChallenge:ok"} {
+		if m.storeRequests[key] != time.Millisecond {
+			t.Errorf("Expected %v to take %v, got %v", key, time.Millisecond, m.storeRequests[key])
+		}
+	}
+
+	// Errors are passed through untouched
+	failing := InstrumentStore(&errStore{err: newStoreError("GetChallenge", ErrStoreRateLimited, errors.New("throttled"))}, m)
+	_, err = failing.GetChallenge("token")
+	if !errors.Is(err, ErrStoreRateLimited) {
+		t.Errorf("Expected %v, got %v", ErrStoreRateLimited, err)
+	}
+	if _, ok := m.storeRequests["GetChallenge:rate_limited"]; !ok {
+		t.Errorf("Expected a rate limited GetChallenge to be recorded")
+	}
+}
+
+func TestResponderWithMetrics(t *testing.T) {
+	store, _ := testRedisStore(t)
+	err := store.PutChallenge(NewChallenge("www.metrics.com", "metricstoken", "metricskeyauth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newRecordingMetrics()
+	h := NewResponder(store).WithMetrics(m).Handler(nil)
+
+	for _, u := range []string{
+		"http://www.metrics.com/.well-known/acme-challenge/metricstoken",
+		"http://www.metrics.com/.well-known/acme-challenge/metricstoken",
+		"http://www.other.com/.well-known/acme-challenge/metricstoken",
+		"http://www.metrics.com/.well-known/acme-challenge/missing",
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, u, nil))
+	}
+
+	helpers.ExpectIntMatch(t, 2, m.lookups[outcomeHit])
+	helpers.ExpectIntMatch(t, 1, m.lookups[outcomeHostMismatch])
+	helpers.ExpectIntMatch(t, 1, m.lookups[outcomeNotFound])
+	helpers.ExpectIntMatch(t, 0, m.inFlight)
+	helpers.ExpectIntMatch(t, 1, m.maxInFlight)
+}
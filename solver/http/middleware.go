@@ -0,0 +1,162 @@
+package http
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ACME tokens are base64url encoded without padding and carry at least 128 bits of
+// entropy (RFC 8555 section 8.1), so anything outside these bounds can't be a
+// token issued by the CA
+const (
+	MinTokenLength = 22
+	MaxTokenLength = 128
+)
+
+// ValidToken reports whether the token could have been issued by the CA
+func ValidToken(token string) bool {
+	if len(token) < MinTokenLength || len(token) > MaxTokenLength {
+		return false
+	}
+
+	for _, c := range token {
+		switch {
+		case c >= 'A' && c <= 'Z':
+		case c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9':
+		case c == '-' || c == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewGinTokenValidator returns a gin middleware that rejects requests whose :token
+// parameter is not a valid ACME token before they reach the Store
+func NewGinTokenValidator() func(*gin.Context) {
+	return func(c *gin.Context) {
+		if !ValidToken(c.Param("token")) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RateLimiter is a per-client token bucket rate limiter
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	rate    float64
+	burst   float64
+	now     func() time.Time
+	metrics Metrics
+	// forwarded keys clients on gin's ClientIP rather than the remote address
+	forwarded bool
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxIdleBuckets is the number of buckets we keep before sweeping out the ones
+// that have refilled, so that the map doesn't grow without bound
+const maxIdleBuckets = 10000
+
+// NewRateLimiter returns a pointer to a RateLimiter that allows each client rate
+// requests per second, with bursts of up to burst requests
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		buckets: map[string]*bucket{},
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		metrics: ExpvarMetrics{},
+	}
+}
+
+// WithMetrics allows you to record rate limited requests somewhere other than
+// expvar
+func (rl *RateLimiter) WithMetrics(m Metrics) *RateLimiter {
+	rl.metrics = m
+	return rl
+}
+
+// WithForwardedFor keys clients on gin's Context.ClientIP, which reads
+// X-Forwarded-For from the engine's trusted proxies. Only use it once the
+// engine's trusted proxies are set with SetTrustedProxies, since by default gin
+// trusts every peer and anyone could pick their own key. By default clients are
+// keyed on the remote address.
+func (rl *RateLimiter) WithForwardedFor() *RateLimiter {
+	rl.forwarded = true
+	return rl
+}
+
+// Allow takes a token from the client's bucket, returning false if it is empty
+// along with how long until the next token is available
+func (rl *RateLimiter) Allow(client string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+
+	b, ok := rl.buckets[client]
+	if !ok {
+		if len(rl.buckets) >= maxIdleBuckets {
+			rl.sweep(now)
+		}
+		b = &bucket{
+			tokens: rl.burst,
+			last:   now,
+		}
+		rl.buckets[client] = b
+	}
+
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes buckets that would be full by now, since a new bucket is equivalent
+func (rl *RateLimiter) sweep(now time.Time) {
+	for k, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, k)
+		}
+	}
+}
+
+// GinHandlerFunc returns a gin middleware that limits requests per client IP
+func (rl *RateLimiter) GinHandlerFunc() func(*gin.Context) {
+	return func(c *gin.Context) {
+		client := c.RemoteIP()
+		if rl.forwarded {
+			client = c.ClientIP()
+		}
+
+		ok, wait := rl.Allow(client)
+		if !ok {
+			rl.metrics.ObserveLookup(outcomeClientRateLimited)
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+
+		c.Next()
+	}
+}
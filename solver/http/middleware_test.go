@@ -0,0 +1,141 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+func TestValidToken(t *testing.T) {
+	tests := []struct {
+		token string
+		valid bool
+	}{
+		{"LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0", true},
+		{"abcdefghijklmnopqrstuv", true},
+		{"abc-DEF_0123456789abcdef", true},
+		{"short", false},
+		{strings.Repeat("a", MaxTokenLength+1), false},
+		{"LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0=", false},
+		{"LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDf+wyjxAjEuX0", false},
+		{"../../../../../../etc/passwd", false},
+	}
+
+	for _, tt := range tests {
+		if ValidToken(tt.token) != tt.valid {
+			t.Errorf("ValidToken(%q): expected %v", tt.token, tt.valid)
+		}
+	}
+}
+
+func TestNewGinTokenValidator(t *testing.T) {
+	r := gin.New()
+	r.GET("/.well-known/acme-challenge/:token", NewGinTokenValidator(), func(c *gin.Context) {
+		c.String(http.StatusOK, "OK")
+	})
+
+	tests := []struct {
+		token string
+		code  int
+	}{
+		{"LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0", http.StatusOK},
+		{"wp-login.php", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/acme-challenge/"+tt.token, nil))
+
+		helpers.ExpectIntMatch(t, tt.code, w.Code)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := testNow
+	rl := NewRateLimiter(1, 2)
+	rl.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.Allow("a"); !ok {
+			t.Fatalf("Expected request %d to be allowed", i)
+		}
+	}
+
+	ok, wait := rl.Allow("a")
+	if ok {
+		t.Fatal("Expected the burst to be exhausted")
+	}
+	if wait != time.Second {
+		t.Errorf("Expected to wait %v, got %v", time.Second, wait)
+	}
+
+	// Other clients have their own bucket
+	if ok, _ := rl.Allow("b"); !ok {
+		t.Error("Expected a different client to be allowed")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := rl.Allow("a"); !ok {
+		t.Error("Expected the bucket to have refilled")
+	}
+}
+
+func TestRateLimiterGinHandlerFunc(t *testing.T) {
+	rl := NewRateLimiter(0.5, 1)
+
+	r := gin.New()
+	r.GET("/", rl.GinHandlerFunc(), func(c *gin.Context) {
+		c.String(http.StatusOK, "OK")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	helpers.ExpectIntMatch(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	helpers.ExpectIntMatch(t, http.StatusTooManyRequests, w.Code)
+	helpers.ExpectStringMatch(t, "2", w.Header().Get("Retry-After"))
+}
+
+func TestRateLimiterGinHandlerFunc_forwardedFor(t *testing.T) {
+	request := func(r *gin.Engine, remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	ok := func(c *gin.Context) {
+		c.String(http.StatusOK, "OK")
+	}
+
+	// gin trusts every proxy by default, but a rotating X-Forwarded-For doesn't
+	// get a client a new bucket
+	r := gin.New()
+	r.GET("/", NewRateLimiter(0.5, 1).GinHandlerFunc(), ok)
+	helpers.ExpectIntMatch(t, http.StatusOK, request(r, "192.0.2.1:1234", "198.51.100.1"))
+	helpers.ExpectIntMatch(t, http.StatusTooManyRequests, request(r, "192.0.2.1:1234", "198.51.100.2"))
+
+	// Behind a trusted proxy each forwarded client has its own bucket
+	r = gin.New()
+	if err := r.SetTrustedProxies([]string{"192.0.2.1"}); err != nil {
+		t.Fatal(err)
+	}
+	r.GET("/", NewRateLimiter(0.5, 1).WithForwardedFor().GinHandlerFunc(), ok)
+	helpers.ExpectIntMatch(t, http.StatusOK, request(r, "192.0.2.1:1234", "198.51.100.1"))
+	helpers.ExpectIntMatch(t, http.StatusOK, request(r, "192.0.2.1:1234", "198.51.100.2"))
+	helpers.ExpectIntMatch(t, http.StatusTooManyRequests, request(r, "192.0.2.1:1234", "198.51.100.2"))
+
+	// Anyone else's X-Forwarded-For is ignored
+	helpers.ExpectIntMatch(t, http.StatusOK, request(r, "203.0.113.1:1234", "198.51.100.3"))
+	helpers.ExpectIntMatch(t, http.StatusTooManyRequests, request(r, "203.0.113.1:1234", "198.51.100.4"))
+}
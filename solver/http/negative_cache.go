@@ -0,0 +1,124 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultNegativeCacheTTL is how long a NegativeCacheStore remembers a miss
+const DefaultNegativeCacheTTL = 2 * time.Second
+
+// maxNegativeCacheEntries bounds the memory used by a NegativeCacheStore
+const maxNegativeCacheEntries = 10000
+
+// NegativeCacheStore wraps a Store and briefly remembers tokens that were not
+// found, so that scanners requesting random tokens don't each cost a lookup in
+// the underlying Store. Challenges written through the NegativeCacheStore clear
+// the cached miss immediately; challenges written elsewhere become visible once
+// the miss expires, so keep the TTL short.
+type NegativeCacheStore struct {
+	Store
+
+	mu     sync.Mutex
+	misses map[string]time.Time
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewNegativeCacheStore returns a pointer to a NegativeCacheStore wrapping store
+func NewNegativeCacheStore(store Store, ttl time.Duration) *NegativeCacheStore {
+	return &NegativeCacheStore{
+		Store:  store,
+		misses: map[string]time.Time{},
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// DeleteChallenge deletes the challenge from the underlying Store
+func (ns *NegativeCacheStore) DeleteChallenge(token string) error {
+	return ns.DeleteChallengeWithContext(context.Background(), token)
+}
+
+// DeleteChallengeWithContext deletes the challenge from the underlying Store
+func (ns *NegativeCacheStore) DeleteChallengeWithContext(ctx context.Context, token string) error {
+	ns.forget(token)
+	return ns.Store.DeleteChallengeWithContext(ctx, token)
+}
+
+// GetChallenge returns a cached miss, or retrieves the challenge from the
+// underlying Store
+func (ns *NegativeCacheStore) GetChallenge(token string) (*Challenge, error) {
+	return ns.GetChallengeWithContext(context.Background(), token)
+}
+
+// GetChallengeWithContext returns a cached miss, or retrieves the challenge from
+// the underlying Store
+func (ns *NegativeCacheStore) GetChallengeWithContext(ctx context.Context, token string) (*Challenge, error) {
+	if ns.cachedMiss(token) {
+		return nil, newStoreError("GetChallenge", ErrStoreNotFound, nil)
+	}
+
+	ch, err := ns.Store.GetChallengeWithContext(ctx, token)
+	if errors.Is(err, ErrStoreNotFound) {
+		ns.remember(token)
+	}
+
+	return ch, err
+}
+
+// PutChallenge writes the challenge to the underlying Store
+func (ns *NegativeCacheStore) PutChallenge(ch *Challenge) error {
+	return ns.PutChallengeWithContext(context.Background(), ch)
+}
+
+// PutChallengeWithContext writes the challenge to the underlying Store
+func (ns *NegativeCacheStore) PutChallengeWithContext(ctx context.Context, ch *Challenge) error {
+	ns.forget(ch.Token)
+	return ns.Store.PutChallengeWithContext(ctx, ch)
+}
+
+func (ns *NegativeCacheStore) cachedMiss(token string) bool {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	expiresAt, ok := ns.misses[token]
+	if !ok {
+		return false
+	}
+
+	if !ns.now().Before(expiresAt) {
+		delete(ns.misses, token)
+		return false
+	}
+
+	return true
+}
+
+func (ns *NegativeCacheStore) remember(token string) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	now := ns.now()
+	if len(ns.misses) >= maxNegativeCacheEntries {
+		for k, expiresAt := range ns.misses {
+			if !now.Before(expiresAt) {
+				delete(ns.misses, k)
+			}
+		}
+	}
+
+	// If everything is still fresh we'd rather skip caching than grow forever
+	if len(ns.misses) < maxNegativeCacheEntries {
+		ns.misses[token] = now.Add(ns.ttl)
+	}
+}
+
+func (ns *NegativeCacheStore) forget(token string) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	delete(ns.misses, token)
+}
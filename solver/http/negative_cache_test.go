@@ -0,0 +1,56 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// countingStore counts the lookups that reach the underlying Store
+type countingStore struct {
+	Store
+	gets int
+}
+
+func (cs *countingStore) GetChallengeWithContext(ctx context.Context, token string) (*Challenge, error) {
+	cs.gets++
+	return cs.Store.GetChallengeWithContext(ctx, token)
+}
+
+func TestNegativeCacheStore(t *testing.T) {
+	redisStore, _ := testRedisStore(t)
+	cs := &countingStore{Store: redisStore}
+
+	now := testNow
+	store := NewNegativeCacheStore(cs, time.Second)
+	store.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, err := store.GetChallenge("token")
+		if !errors.Is(err, ErrStoreNotFound) {
+			t.Fatalf("Expected %v, got %v", ErrStoreNotFound, err)
+		}
+	}
+	helpers.ExpectIntMatch(t, 1, cs.gets)
+
+	// The miss expires
+	now = now.Add(time.Second)
+	store.GetChallenge("token")
+	helpers.ExpectIntMatch(t, 2, cs.gets)
+
+	// Writing the challenge clears the miss
+	err := store.PutChallenge(NewChallenge("www.com", "token", "keyauth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := store.GetChallenge("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectStringMatch(t, "keyauth", ch.KeyAuth)
+	helpers.ExpectIntMatch(t, 3, cs.gets)
+}
@@ -0,0 +1,60 @@
+// package prommetrics records challenge traffic from the http solver package with
+// Prometheus. It lives in its own package so that library users who don't want
+// Prometheus don't have to build it.
+package prommetrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "acme_sls"
+
+// Metrics implements http.Metrics using Prometheus collectors
+type Metrics struct {
+	lookups       *prometheus.CounterVec
+	storeRequests *prometheus.HistogramVec
+	inFlight      prometheus.Gauge
+}
+
+// New returns a pointer to Metrics, with its collectors registered with reg
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "challenge_lookups_total",
+			Help:      "Challenge requests by outcome.",
+		}, []string{"outcome"}),
+		storeRequests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_request_duration_seconds",
+			Help:      "Duration of challenge Store operations by op and outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"op", "outcome"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "challenge_requests_in_flight",
+			Help:      "Challenge requests currently being served.",
+		}),
+	}
+
+	reg.MustRegister(m.lookups, m.storeRequests, m.inFlight)
+
+	return m
+}
+
+// ObserveLookup implements http.Metrics
+func (m *Metrics) ObserveLookup(outcome string) {
+	m.lookups.WithLabelValues(outcome).Inc()
+}
+
+// ObserveStoreRequest implements http.Metrics
+func (m *Metrics) ObserveStoreRequest(op, outcome string, d time.Duration) {
+	m.storeRequests.WithLabelValues(op, outcome).Observe(d.Seconds())
+}
+
+// InFlight implements http.Metrics
+func (m *Metrics) InFlight(delta int) {
+	m.inFlight.Add(float64(delta))
+}
@@ -0,0 +1,39 @@
+package prommetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	solver "github.com/sjauld/acme-sls/solver/http"
+)
+
+// Metrics must be usable wherever the solver expects them
+var _ solver.Metrics = &Metrics{}
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := New(reg)
+
+	m.ObserveLookup("hit")
+	m.ObserveLookup("hit")
+	m.ObserveLookup("not_found")
+	m.ObserveStoreRequest("GetChallenge", "ok", 10*time.Millisecond)
+	m.InFlight(1)
+	m.InFlight(1)
+	m.InFlight(-1)
+
+	if got := testutil.ToFloat64(m.lookups.WithLabelValues("hit")); got != 2 {
+		t.Errorf("Expected 2 hits, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.lookups.WithLabelValues("not_found")); got != 1 {
+		t.Errorf("Expected 1 miss, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.inFlight); got != 1 {
+		t.Errorf("Expected 1 request in flight, got %v", got)
+	}
+	if got := testutil.CollectAndCount(m.storeRequests); got != 1 {
+		t.Errorf("Expected 1 store histogram, got %v", got)
+	}
+}
@@ -0,0 +1,63 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// NewRedirectHandler returns an http.Handler that redirects every request to
+// https, preserving the host, path and query. This allows the responder to sit on
+// port 80 in front of an application: wrap the redirect handler with NewHandler
+// and challenges are answered while everything else is sent to https.
+//
+// code must be http.StatusMovedPermanently or http.StatusPermanentRedirect. Only
+// requests for a host in allowedHosts are redirected, other hosts receive a 404.
+// If allowedHosts is empty, requests for any host are redirected.
+func NewRedirectHandler(code int, allowedHosts []string) (http.Handler, error) {
+	if code != http.StatusMovedPermanently && code != http.StatusPermanentRedirect {
+		return nil, fmt.Errorf("redirect status code must be %d or %d, got %d", http.StatusMovedPermanently, http.StatusPermanentRedirect, code)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// The port is dropped, since the redirect should go to the default https port
+		host, err := NormaliseHost(req.Host)
+		if err != nil || host == "" || !redirectAllowed(host, allowedHosts) {
+			http.NotFound(w, req)
+			return
+		}
+
+		if strings.Contains(host, ":") {
+			// IPv6 literal
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + req.URL.RequestURI()
+		http.Redirect(w, req, target, code)
+	}), nil
+}
+
+// redirectAllowed checks the normalised host against the allowlist
+func redirectAllowed(host string, allowedHosts []string) bool {
+	if len(allowedHosts) == 0 {
+		return true
+	}
+
+	for _, h := range allowedHosts {
+		h, err := NormaliseHost(h)
+		if err != nil {
+			continue
+		}
+
+		// *.example.com allows any subdomain of example.com
+		if strings.HasPrefix(h, wildcardPrefix) && strings.HasSuffix(host, h[1:]) {
+			return true
+		}
+
+		if host == h {
+			return true
+		}
+	}
+
+	return false
+}
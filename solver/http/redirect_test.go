@@ -0,0 +1,78 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+func TestNewRedirectHandler(t *testing.T) {
+	h, err := NewRedirectHandler(http.StatusPermanentRedirect, []string{"www.redirect.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url      string
+		code     int
+		location string
+	}{
+		{"http://www.redirect.com/path?q=1&r=2", http.StatusPermanentRedirect, "https://www.redirect.com/path?q=1&r=2"},
+		{"http://WWW.Redirect.com:80/", http.StatusPermanentRedirect, "https://www.redirect.com/"},
+		{"http://www.evil.com/path", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+		helpers.ExpectIntMatch(t, tt.code, w.Code)
+		helpers.ExpectStringMatch(t, tt.location, w.Header().Get("Location"))
+	}
+}
+
+func TestNewRedirectHandler_anyHost(t *testing.T) {
+	h, err := NewRedirectHandler(http.StatusMovedPermanently, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://www.anything.com/a/b", nil))
+
+	helpers.ExpectIntMatch(t, http.StatusMovedPermanently, w.Code)
+	helpers.ExpectStringMatch(t, "https://www.anything.com/a/b", w.Header().Get("Location"))
+}
+
+func TestNewRedirectHandler_invalidCode(t *testing.T) {
+	_, err := NewRedirectHandler(http.StatusFound, nil)
+	if err == nil {
+		t.Error("Expected an error for a 302 redirect")
+	}
+}
+
+func TestNewRedirectHandler_wildcard(t *testing.T) {
+	h, err := NewRedirectHandler(http.StatusPermanentRedirect, []string{"*.redirect.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		code int
+	}{
+		{"http://www.redirect.com/", http.StatusPermanentRedirect},
+		{"http://a.b.redirect.com/", http.StatusPermanentRedirect},
+		{"http://redirect.com/", http.StatusNotFound},
+		{"http://evilredirect.com/", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+		helpers.ExpectIntMatch(t, tt.code, w.Code)
+	}
+}
@@ -0,0 +1,135 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DefaultRedisTTL is the expiry applied to challenges written to Redis. A
+// challenge is only needed for the few minutes it takes the CA to validate it,
+// so anything left behind by a crashed client will disappear on its own.
+const DefaultRedisTTL = time.Hour
+
+// DefaultRedisKeyPrefix is prepended to the token to build the Redis key
+const DefaultRedisKeyPrefix = "acme-sls:challenge:"
+
+// RedisStore is an implementation of Store using Redis to persist Challenges
+type RedisStore struct {
+	c      redis.Cmdable
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisStore returns a pointer to a RedisStore. Any redis.Cmdable can be
+// used, so single node, cluster and ring clients are all supported.
+func NewRedisStore(c redis.Cmdable) *RedisStore {
+	return &RedisStore{
+		c:      c,
+		prefix: DefaultRedisKeyPrefix,
+		ttl:    DefaultRedisTTL,
+	}
+}
+
+// WithKeyPrefix allows you to override the prefix used for the Redis keys,
+// which is helpful if the Redis instance is shared with other applications
+func (rs *RedisStore) WithKeyPrefix(prefix string) *RedisStore {
+	rs.prefix = prefix
+	return rs
+}
+
+// WithTTL allows you to override the expiry of the challenges written to Redis
+func (rs *RedisStore) WithTTL(t time.Duration) *RedisStore {
+	rs.ttl = t
+	return rs
+}
+
+// DeleteChallenge deletes the relevant key from Redis
+func (rs *RedisStore) DeleteChallenge(token string) error {
+	return rs.DeleteChallengeWithContext(context.Background(), token)
+}
+
+// DeleteChallengeWithContext deletes the relevant key from Redis
+func (rs *RedisStore) DeleteChallengeWithContext(ctx context.Context, token string) error {
+	err := rs.c.Del(ctx, rs.key(token)).Err()
+
+	return parseRedisError("DeleteChallenge", err)
+}
+
+// GetChallenge retrieves the relevant key from Redis and returns it as a pointer
+// to a Challenge
+func (rs *RedisStore) GetChallenge(token string) (*Challenge, error) {
+	return rs.GetChallengeWithContext(context.Background(), token)
+}
+
+// GetChallengeWithContext retrieves the relevant key from Redis and returns it as
+// a pointer to a Challenge
+func (rs *RedisStore) GetChallengeWithContext(ctx context.Context, token string) (*Challenge, error) {
+	b, err := rs.c.Get(ctx, rs.key(token)).Bytes()
+	if err != nil {
+		return nil, parseRedisError("GetChallenge", err)
+	}
+
+	var ch Challenge
+	if err := json.Unmarshal(b, &ch); err != nil {
+		return nil, newStoreError("GetChallenge", nil, err)
+	}
+
+	return &ch, nil
+}
+
+// PutChallenge serialises a Challenge and writes it to Redis with an expiry
+func (rs *RedisStore) PutChallenge(ch *Challenge) error {
+	return rs.PutChallengeWithContext(context.Background(), ch)
+}
+
+// PutChallengeWithContext serialises a Challenge and writes it to Redis with an
+// expiry
+func (rs *RedisStore) PutChallengeWithContext(ctx context.Context, ch *Challenge) error {
+	b, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+
+	err = rs.c.Set(ctx, rs.key(ch.Token), b, rs.ttl).Err()
+	return parseRedisError("PutChallenge", err)
+}
+
+func (rs *RedisStore) key(token string) string {
+	return rs.prefix + token
+}
+
+// Redis reports transient server side conditions with these error prefixes
+var redisRateLimitedPrefixes = []string{
+	"BUSY",
+	"LOADING",
+	"TRYAGAIN",
+	"ERR max number of clients reached",
+}
+
+// parseRedisError checks for known Redis replies to see if we can return a meaningful error
+func parseRedisError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if err == redis.Nil {
+		// Challenge didn't exist (or has expired)
+		return newStoreError(op, ErrStoreNotFound, nil)
+	}
+
+	if _, ok := err.(redis.Error); ok {
+		for _, prefix := range redisRateLimitedPrefixes {
+			if strings.HasPrefix(err.Error(), prefix) {
+				// The server is overloaded or not ready to serve us
+				return newStoreError(op, ErrStoreRateLimited, err)
+			}
+		}
+	}
+
+	// Some other unexpected error condition
+	return newStoreError(op, nil, err)
+}
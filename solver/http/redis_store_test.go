@@ -0,0 +1,109 @@
+package http
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+func testRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	c := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	t.Cleanup(func() { c.Close() })
+
+	return NewRedisStore(c), mr
+}
+
+func TestRedisStorePutChallenge(t *testing.T) {
+	store, mr := testRedisStore(t)
+
+	err := store.PutChallenge(NewChallenge("a", "b", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := mr.Get(DefaultRedisKeyPrefix + "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectStringMatch(t, `{"domain":"a","token":"b","keyAuth":"c"}`, v)
+
+	if mr.TTL(DefaultRedisKeyPrefix+"b") != DefaultRedisTTL {
+		t.Errorf("Expected TTL %v, got %v", DefaultRedisTTL, mr.TTL(DefaultRedisKeyPrefix+"b"))
+	}
+}
+
+func TestRedisStoreGetChallenge(t *testing.T) {
+	store, _ := testRedisStore(t)
+
+	err := store.PutChallenge(NewChallenge("a", "b", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := store.GetChallenge("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, "a", ch.Domain)
+	helpers.ExpectStringMatch(t, "b", ch.Token)
+	helpers.ExpectStringMatch(t, "c", ch.KeyAuth)
+}
+
+func TestRedisStoreGetChallenge_expired(t *testing.T) {
+	store, mr := testRedisStore(t)
+	store.WithTTL(time.Minute)
+
+	err := store.PutChallenge(NewChallenge("a", "b", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mr.FastForward(2 * time.Minute)
+
+	_, err = store.GetChallenge("b")
+	if !errors.Is(err, ErrStoreNotFound) {
+		t.Errorf("Expected %v, got %v", ErrStoreNotFound, err)
+	}
+}
+
+func TestRedisStoreDeleteChallenge(t *testing.T) {
+	store, mr := testRedisStore(t)
+	store.WithKeyPrefix("custom:")
+
+	err := store.PutChallenge(NewChallenge("a", "b", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !mr.Exists("custom:b") {
+		t.Fatal("Expected the challenge to be stored under the custom prefix")
+	}
+
+	err = store.DeleteChallenge("b")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if mr.Exists("custom:b") {
+		t.Error("Expected the challenge to be deleted")
+	}
+}
+
+func TestRedisStore_rateLimited(t *testing.T) {
+	store, mr := testRedisStore(t)
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+
+	_, err := store.GetChallenge("b")
+	if !errors.Is(err, ErrStoreRateLimited) {
+		t.Errorf("Expected %v, got %v", ErrStoreRateLimited, err)
+	}
+}
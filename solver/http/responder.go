@@ -1,50 +1,195 @@
 package http
 
 import (
+	"errors"
+	"expvar"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Outcomes of a challenge lookup, used to label the lookup counters
+const (
+	outcomeHit           = "hit"
+	outcomeForwarded     = "forwarded"
+	outcomeNotFound      = "not_found"
+	outcomeHostMismatch  = "host_mismatch"
+	outcomeRateLimited   = "rate_limited"
+	outcomeTableNotFound = "table_not_found"
+	outcomeError         = "error"
+
+	// The client was rate limited before we looked up the Store
+	outcomeClientRateLimited = "client_rate_limited"
+)
+
+// lookups counts challenge requests by outcome. It is published via expvar.
+var lookups = expvar.NewMap("acme_sls_challenge_lookups")
+
+// ChallengePathPrefix is the well known path at which the remote CA requests the
+// keyauth for a token
+const ChallengePathPrefix = "/.well-known/acme-challenge/"
+
+// Responder answers challenge requests from the remote CA using a Store
+type Responder struct {
+	store   Store
+	metrics Metrics
+
+	// Optional upstream responder, used when the Store doesn't have the challenge
+	upstream *url.URL
+	client   *http.Client
+}
+
+// NewResponder returns a pointer to a Responder, initialised with a Store of your
+// choice
+func NewResponder(store Store) *Responder {
+	return &Responder{
+		store:   store,
+		metrics: ExpvarMetrics{},
+	}
+}
+
+// WithMetrics allows you to record lookups somewhere other than expvar
+func (r *Responder) WithMetrics(m Metrics) *Responder {
+	r.metrics = m
+	return r
+}
+
 // NewGinHandlerFunc returns a gin.HandlerFunc that will parse the incoming challenge
 // request from the remote CA, retrieve the challenge data from the Store, and return
 // an appropriate response.
 func NewGinHandlerFunc(store Store) func(*gin.Context) {
+	return NewResponder(store).GinHandlerFunc()
+}
+
+// NewHandler returns an http.Handler that will answer challenge requests from the
+// remote CA at ChallengePathPrefix using the Store, and pass every other request
+// through to next. This allows the responder to be embedded as middleware in an
+// existing server. If next is nil, other requests receive a 404.
+func NewHandler(store Store, next http.Handler) http.Handler {
+	return NewResponder(store).Handler(next)
+}
+
+// GinHandlerFunc returns a gin.HandlerFunc for a route with a :token parameter
+func (r *Responder) GinHandlerFunc() func(*gin.Context) {
 	return func(c *gin.Context) {
 		log.Printf("[DEBUG] request %+v", c)
 
-		ch, err := store.GetChallenge(c.Param("token"))
-		if err != nil {
-			log.Printf("[ERROR] could not GetChallenge: %v", err)
-			perr := parseDynamoDBError(err)
-			switch perr {
-			case ErrStoreNotFound:
-				c.String(http.StatusNotFound, "Challenge not found")
-			case ErrStoreRateLimited:
-				c.String(http.StatusTooManyRequests, "Please try again soon")
-			default:
-				c.String(http.StatusInternalServerError, "Unexpected error")
-			}
-			return
-		}
+		r.serveChallenge(c.Writer, c.Request, c.Param("token"))
+	}
+}
+
+// Handler returns an http.Handler that answers challenge requests at
+// ChallengePathPrefix and passes every other request through to next. If next is
+// nil, other requests receive a 404.
+func (r *Responder) Handler(next http.Handler) http.Handler {
+	if next == nil {
+		next = http.NotFoundHandler()
+	}
 
-		ok := validateChallenge(c.Request, ch)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token, ok := challengeToken(req)
 		if !ok {
-			c.String(http.StatusNotFound, "Challenge not found")
+			next.ServeHTTP(w, req)
 			return
 		}
 
-		c.String(http.StatusOK, ch.KeyAuth)
+		log.Printf("[DEBUG] request %+v", req)
+
+		r.serveChallenge(w, req, token)
+	})
+}
+
+// challengeToken extracts the token from a challenge request. Only GET and HEAD
+// requests for a single path segment below ChallengePathPrefix are considered.
+func challengeToken(req *http.Request) (string, bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return "", false
+	}
+
+	if !strings.HasPrefix(req.URL.Path, ChallengePathPrefix) {
+		return "", false
+	}
+
+	token := strings.TrimPrefix(req.URL.Path, ChallengePathPrefix)
+	if token == "" || strings.Contains(token, "/") {
+		return "", false
+	}
+
+	return token, true
+}
+
+// serveChallenge retrieves the challenge data from the Store and writes the
+// appropriate response
+func (r *Responder) serveChallenge(w http.ResponseWriter, req *http.Request, token string) {
+	r.metrics.InFlight(1)
+	defer r.metrics.InFlight(-1)
+
+	hit := outcomeHit
+	ch, err := r.store.GetChallengeWithContext(req.Context(), token)
+	if errors.Is(err, ErrStoreNotFound) && r.canForward(req) {
+		log.Printf("[INFO] challenge not found in the store, forwarding to %v", r.upstream)
+		hit = outcomeForwarded
+		ch, err = r.forward(req, token)
+	}
+	if err != nil {
+		log.Printf("[ERROR] could not GetChallenge: %v", err)
+		outcome := errorOutcome(err)
+		r.metrics.ObserveLookup(outcome)
+
+		switch outcome {
+		case outcomeNotFound:
+			writeString(w, http.StatusNotFound, "Challenge not found")
+		case outcomeRateLimited:
+			w.Header().Set("Retry-After", "1")
+			writeString(w, http.StatusTooManyRequests, "Please try again soon")
+		case outcomeTableNotFound:
+			writeString(w, http.StatusServiceUnavailable, "Challenge store unavailable")
+		default:
+			writeString(w, http.StatusInternalServerError, "Unexpected error")
+		}
+		return
+	}
+
+	ok := validateChallenge(req, ch)
+	if !ok {
+		r.metrics.ObserveLookup(outcomeHostMismatch)
+		writeString(w, http.StatusNotFound, "Challenge not found")
+		return
+	}
+
+	r.metrics.ObserveLookup(hit)
+	w.Header().Set(ChallengeDomainHeader, ch.Domain)
+	writeString(w, http.StatusOK, ch.KeyAuth)
+}
+
+// writeString writes a plain text response
+func writeString(w http.ResponseWriter, code int, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	io.WriteString(w, body)
+}
+
+// errorOutcome classifies an error returned by a Store
+func errorOutcome(err error) string {
+	switch {
+	case errors.Is(err, ErrStoreNotFound):
+		return outcomeNotFound
+	case errors.Is(err, ErrStoreRateLimited):
+		return outcomeRateLimited
+	case errors.Is(err, ErrStoreTableNotFound):
+		return outcomeTableNotFound
+	default:
+		return outcomeError
 	}
 }
 
 // We just need to check that the hostname we stored for the token is the same as the
 // hostname in the HTTP request
 func validateChallenge(req *http.Request, ch *Challenge) bool {
-	reqHost := strings.Split(req.Host, ":")[0]
-	expectedHost := ch.Domain
-	log.Printf("[DEBUG] request host: %v, expected host: %v", reqHost, expectedHost)
-	return reqHost == expectedHost
+	log.Printf("[DEBUG] request host: %v, expected host: %v", req.Host, ch.Domain)
+	return MatchHost(req.Host, ch.Domain)
 }
@@ -1,6 +1,8 @@
 package http
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -23,8 +25,10 @@ func TestValidateChallenge_valid(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Server requests have the host in req.Host, not the URL
 	req := &http.Request{
-		URL: url,
+		URL:  url,
+		Host: url.Host,
 	}
 
 	if !validateChallenge(req, ch) {
@@ -42,8 +46,10 @@ func TestValidateChallenge_invalid(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Server requests have the host in req.Host, not the URL
 	req := &http.Request{
-		URL: url,
+		URL:  url,
+		Host: url.Host,
 	}
 
 	if validateChallenge(req, ch) {
@@ -66,7 +72,8 @@ func TestNewGinHandlerFunc_valid(t *testing.T) {
 	}
 
 	req := &http.Request{
-		URL: requestURL,
+		URL:  requestURL,
+		Host: requestURL.Host,
 	}
 
 	c.Request = req
@@ -101,3 +108,102 @@ func TestNewGinHandlerFunc_valid(t *testing.T) {
 	helpers.ExpectIntMatch(t, http.StatusOK, w.Code)
 	helpers.ExpectStringMatch(t, "ginhandlerfunckeyauth", w.Body.String())
 }
+
+// errStore is a Store that always fails with the same error
+type errStore struct {
+	err error
+}
+
+func (s *errStore) DeleteChallenge(string) error {
+	return s.err
+}
+
+func (s *errStore) DeleteChallengeWithContext(context.Context, string) error {
+	return s.err
+}
+
+func (s *errStore) GetChallenge(string) (*Challenge, error) {
+	return nil, s.err
+}
+
+func (s *errStore) GetChallengeWithContext(context.Context, string) (*Challenge, error) {
+	return nil, s.err
+}
+
+func (s *errStore) PutChallenge(*Challenge) error {
+	return s.err
+}
+
+func (s *errStore) PutChallengeWithContext(context.Context, *Challenge) error {
+	return s.err
+}
+
+func TestNewGinHandlerFunc_errors(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{newStoreError("GetChallenge", ErrStoreNotFound, nil), http.StatusNotFound},
+		{newStoreError("GetChallenge", ErrStoreRateLimited, errors.New("throttled")), http.StatusTooManyRequests},
+		{newStoreError("GetChallenge", ErrStoreTableNotFound, errors.New("no table")), http.StatusServiceUnavailable},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		f := NewGinHandlerFunc(&errStore{err: tt.err})
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "http://www.com/.well-known/acme-challenge/token", nil)
+
+		f(c)
+
+		helpers.ExpectIntMatch(t, tt.code, w.Code)
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	store, _ := testRedisStore(t)
+	err := store.PutChallenge(NewChallenge("www.handler.com", "handlertoken", "handlerkeyauth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := NewHandler(store, next)
+
+	tests := []struct {
+		method string
+		url    string
+		code   int
+		body   string
+	}{
+		{http.MethodGet, "http://www.handler.com/.well-known/acme-challenge/handlertoken", http.StatusOK, "handlerkeyauth"},
+		{http.MethodGet, "http://www.other.com/.well-known/acme-challenge/handlertoken", http.StatusNotFound, "Challenge not found"},
+		{http.MethodGet, "http://www.handler.com/.well-known/acme-challenge/missing", http.StatusNotFound, "Challenge not found"},
+		{http.MethodGet, "http://www.handler.com/", http.StatusTeapot, ""},
+		{http.MethodGet, "http://www.handler.com/.well-known/acme-challenge/", http.StatusTeapot, ""},
+		{http.MethodGet, "http://www.handler.com/.well-known/acme-challenge/handlertoken/extra", http.StatusTeapot, ""},
+		{http.MethodPost, "http://www.handler.com/.well-known/acme-challenge/handlertoken", http.StatusTeapot, ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, nil))
+
+		helpers.ExpectIntMatch(t, tt.code, w.Code)
+		helpers.ExpectStringMatch(t, tt.body, w.Body.String())
+	}
+}
+
+func TestNewHandler_nilNext(t *testing.T) {
+	store, _ := testRedisStore(t)
+	h := NewHandler(store, nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://www.handler.com/", nil))
+
+	helpers.ExpectIntMatch(t, http.StatusNotFound, w.Code)
+}
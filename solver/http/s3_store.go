@@ -0,0 +1,145 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// s3ErrCodeSlowDown is returned by S3 when we exceed the request rate for a prefix
+const s3ErrCodeSlowDown = "SlowDown"
+
+// s3ErrCodeAccessDenied is returned by S3 for a missing key, instead of
+// NoSuchKey, when the caller doesn't have s3:ListBucket
+const s3ErrCodeAccessDenied = "AccessDenied"
+
+// S3Store is an implementation of Store using AWS S3 to persist Challenges. Each
+// Challenge is serialised as a JSON object named after its token, so a single
+// bucket (optionally replicated) can back many servers. Without s3:ListBucket,
+// S3 denies access to a missing object, so an AccessDenied read is treated as
+// not found.
+type S3Store struct {
+	c      s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// NewS3Store returns a pointer to an S3Store. Objects are written under the
+// given key prefix, which may be empty.
+func NewS3Store(c s3iface.S3API, bucket, prefix string) *S3Store {
+	return &S3Store{
+		c:      c,
+		bucket: bucket,
+		prefix: prefix,
+	}
+}
+
+// DeleteChallenge deletes the relevant object from S3
+func (ss *S3Store) DeleteChallenge(token string) error {
+	return ss.DeleteChallengeWithContext(context.Background(), token)
+}
+
+// DeleteChallengeWithContext deletes the relevant object from S3
+func (ss *S3Store) DeleteChallengeWithContext(ctx context.Context, token string) error {
+	in := &s3.DeleteObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(ss.key(token)),
+	}
+	_, err := ss.c.DeleteObjectWithContext(ctx, in)
+
+	return parseS3Error("DeleteChallenge", err)
+}
+
+// GetChallenge retrieves the relevant object from S3 and returns it as a pointer
+// to a Challenge
+func (ss *S3Store) GetChallenge(token string) (*Challenge, error) {
+	return ss.GetChallengeWithContext(context.Background(), token)
+}
+
+// GetChallengeWithContext retrieves the relevant object from S3 and returns it as
+// a pointer to a Challenge
+func (ss *S3Store) GetChallengeWithContext(ctx context.Context, token string) (*Challenge, error) {
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(ss.key(token)),
+	}
+
+	resp, err := ss.c.GetObjectWithContext(ctx, in)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3ErrCodeAccessDenied {
+		return nil, newStoreError("GetChallenge", ErrStoreNotFound, err)
+	}
+	if err != nil {
+		return nil, parseS3Error("GetChallenge", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, newStoreError("GetChallenge", nil, err)
+	}
+
+	var ch Challenge
+	if err := json.Unmarshal(b, &ch); err != nil {
+		return nil, newStoreError("GetChallenge", nil, err)
+	}
+
+	return &ch, nil
+}
+
+// PutChallenge serialises a Challenge and writes it to an object in S3
+func (ss *S3Store) PutChallenge(ch *Challenge) error {
+	return ss.PutChallengeWithContext(context.Background(), ch)
+}
+
+// PutChallengeWithContext serialises a Challenge and writes it to an object in S3
+func (ss *S3Store) PutChallengeWithContext(ctx context.Context, ch *Challenge) error {
+	b, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+
+	in := &s3.PutObjectInput{
+		Body:        bytes.NewReader(b),
+		Bucket:      aws.String(ss.bucket),
+		ContentType: aws.String("application/json"),
+		Key:         aws.String(ss.key(ch.Token)),
+	}
+
+	_, err = ss.c.PutObjectWithContext(ctx, in)
+	return parseS3Error("PutChallenge", err)
+}
+
+func (ss *S3Store) key(token string) string {
+	return path.Join(ss.prefix, token+".json")
+}
+
+// parseS3Error checks for known S3 response codes to see if we can return a meaningful error
+func parseS3Error(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3ErrCodeSlowDown:
+			// We exceeded the S3 request rate
+			return newStoreError(op, ErrStoreRateLimited, err)
+		case s3.ErrCodeNoSuchKey:
+			// Challenge didn't exist
+			return newStoreError(op, ErrStoreNotFound, err)
+		case s3.ErrCodeNoSuchBucket:
+			// The bucket didn't exist
+			return newStoreError(op, ErrStoreTableNotFound, err)
+		}
+	}
+
+	// Some other unexpected error condition
+	return newStoreError(op, nil, err)
+}
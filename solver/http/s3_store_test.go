@@ -0,0 +1,137 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// fakeS3 is an in-memory stand in for the parts of S3 used by the S3Store
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+	err     error
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects: map[string][]byte{},
+	}
+}
+
+func (f *fakeS3) DeleteObjectWithContext(ctx context.Context, in *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	delete(f.objects, aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx context.Context, in *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	b, ok := f.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewReader(b)),
+	}, nil
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx context.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	b, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[aws.StringValue(in.Bucket)+"/"+aws.StringValue(in.Key)] = b
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestS3StorePutChallenge(t *testing.T) {
+	c := newFakeS3()
+	store := NewS3Store(c, "bucket", "challenges")
+
+	err := store.PutChallenge(NewChallenge("a", "b", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, `{"domain":"a","token":"b","keyAuth":"c"}`, string(c.objects["bucket/challenges/b.json"]))
+}
+
+func TestS3StoreGetChallenge(t *testing.T) {
+	c := newFakeS3()
+	c.objects["bucket/b.json"] = []byte(`{"domain":"a","token":"b","keyAuth":"c"}`)
+	store := NewS3Store(c, "bucket", "")
+
+	ch, err := store.GetChallenge("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, "a", ch.Domain)
+	helpers.ExpectStringMatch(t, "b", ch.Token)
+	helpers.ExpectStringMatch(t, "c", ch.KeyAuth)
+}
+
+func TestS3StoreGetChallenge_notFound(t *testing.T) {
+	store := NewS3Store(newFakeS3(), "bucket", "challenges")
+
+	_, err := store.GetChallenge("b")
+	if !errors.Is(err, ErrStoreNotFound) {
+		t.Errorf("Expected %v, got %v", ErrStoreNotFound, err)
+	}
+}
+
+func TestS3StoreGetChallenge_accessDenied(t *testing.T) {
+	c := newFakeS3()
+	c.err = awserr.New("AccessDenied", "Access Denied", nil)
+	store := NewS3Store(c, "bucket", "challenges")
+
+	// This is how S3 reports a missing key without s3:ListBucket
+	_, err := store.GetChallenge("b")
+	if !errors.Is(err, ErrStoreNotFound) {
+		t.Errorf("Expected %v, got %v", ErrStoreNotFound, err)
+	}
+}
+
+func TestS3StoreGetChallenge_rateLimited(t *testing.T) {
+	c := newFakeS3()
+	c.err = awserr.New("SlowDown", "Please reduce your request rate.", nil)
+	store := NewS3Store(c, "bucket", "challenges")
+
+	_, err := store.GetChallenge("b")
+	if !errors.Is(err, ErrStoreRateLimited) {
+		t.Errorf("Expected %v, got %v", ErrStoreRateLimited, err)
+	}
+}
+
+func TestS3StoreDeleteChallenge(t *testing.T) {
+	c := newFakeS3()
+	c.objects["bucket/challenges/b.json"] = []byte(`{}`)
+	store := NewS3Store(c, "bucket", "challenges")
+
+	err := store.DeleteChallenge("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.objects["bucket/challenges/b.json"]; ok {
+		t.Error("Expected the challenge to be deleted")
+	}
+}
@@ -4,36 +4,60 @@
 // 2. Solver populates the Challenge in the Store and notifies the CA that the challenge is ready
 // 3. remote CA requests the keyauth from the well known path on the server
 // 4. server retrieves the Challenge from the Store, validates the requests and presents the keyauth to the remote CA
+//
+// The server side can be run as a gin route with NewGinHandlerFunc, or embedded in
+// any net/http based server with NewHandler.
 package http
 
 import (
+	"context"
 	"log"
 )
 
 // Solver implements lego's challenge.Provider
 type Solver struct {
+	ctx   context.Context
 	store Store
 }
 
 // New returns a pointer to a Solver, initialised with a Store of your choice
 func New(store Store) *Solver {
 	return &Solver{
+		ctx:   context.Background(),
 		store: store,
 	}
 }
 
+// WithContext sets the context used by Present and CleanUp
+func (s *Solver) WithContext(ctx context.Context) *Solver {
+	s.ctx = ctx
+	return s
+}
+
 // Present writes the challenge information into the Store so that the
 // server can respond to HTTP queries with the correct value
 func (s *Solver) Present(domain, token, keyAuth string) error {
+	return s.PresentWithContext(s.ctx, domain, token, keyAuth)
+}
+
+// PresentWithContext is the same as Present with the addition of the ability
+// to pass a context
+func (s *Solver) PresentWithContext(ctx context.Context, domain, token, keyAuth string) error {
 	log.Printf("[INFO] Presenting domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
 	ch := NewChallenge(domain, token, keyAuth)
 
-	return s.store.PutChallenge(ch)
+	return s.store.PutChallengeWithContext(ctx, ch)
 }
 
 // CleanUp removes the challenge information from the Store
 func (s *Solver) CleanUp(domain, token, keyAuth string) error {
+	return s.CleanUpWithContext(s.ctx, domain, token, keyAuth)
+}
+
+// CleanUpWithContext is the same as CleanUp with the addition of the ability
+// to pass a context
+func (s *Solver) CleanUpWithContext(ctx context.Context, domain, token, keyAuth string) error {
 	log.Printf("[INFO] CleaningUp domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
 
-	return s.store.DeleteChallenge(token)
+	return s.store.DeleteChallengeWithContext(ctx, token)
 }
@@ -1,6 +1,8 @@
 package http
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -11,6 +13,7 @@ import (
 func TestPresent(t *testing.T) {
 	table := petname.Generate(2, "-")
 	store := NewDynamoDBStore(dyn, table)
+	store.now = testClock
 
 	solver := New(store)
 
@@ -18,6 +21,9 @@ func TestPresent(t *testing.T) {
 		"domain": {
 			S: aws.String("testing.com"),
 		},
+		"expiresAt": {
+			N: aws.String("1600003600"),
+		},
 		"keyAuth": {
 			S: aws.String("keyauth"),
 		},
@@ -50,3 +56,16 @@ func TestCleanUp(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestPresentWithContext_cancelled(t *testing.T) {
+	store, _ := testRedisStore(t)
+	solver := New(store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := solver.PresentWithContext(ctx, "testing.com", "token", "keyauth")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+}
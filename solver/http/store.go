@@ -1,8 +1,10 @@
 package http
 
 import (
-	"errors"
+	"context"
 	"log"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -10,39 +12,50 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
-var (
-	ErrStoreRateLimited = errors.New("We were rate limited, try again later")
-	ErrStoreNotFound    = errors.New("Challenge not found in the store")
-)
-
 // Store represents a backend storage system that is used to persist challenge
-// information between the client and server
+// information between the client and server. GetChallenge must return an error
+// of kind ErrStoreNotFound if the challenge does not exist.
+//
+// The WithContext variants allow the caller to cancel the request or impose a
+// deadline; the plain variants are equivalent to calling them with
+// context.Background().
 type Store interface {
 	DeleteChallenge(string) error
+	DeleteChallengeWithContext(context.Context, string) error
 	GetChallenge(string) (*Challenge, error)
+	GetChallengeWithContext(context.Context, string) (*Challenge, error)
 	PutChallenge(*Challenge) error
+	PutChallengeWithContext(context.Context, *Challenge) error
 }
 
 // Challenge represents the information required for an ACMEv2 HTTP-01 challenge
 type Challenge struct {
-	Domain  string
-	Token   string
-	KeyAuth string
+	Domain  string `json:"domain"`
+	Token   string `json:"token"`
+	KeyAuth string `json:"keyAuth"`
 }
 
-// NewChallenge returns a pointer to a Challenge
+// NewChallenge returns a pointer to a Challenge. The domain is normalised with
+// NormaliseDomain so that every Store holds it in the same form.
 func NewChallenge(domain, token, keyAuth string) *Challenge {
 	return &Challenge{
-		Domain:  domain,
+		Domain:  NormaliseDomain(domain),
 		Token:   token,
 		KeyAuth: keyAuth,
 	}
 }
 
+// DefaultDynamoDBTTL is how long a challenge row remains valid after it is
+// written to DynamoDB. If a client dies before CleanUp runs, the row will be
+// ignored after this time and eventually removed by DynamoDB's TTL process.
+const DefaultDynamoDBTTL = time.Hour
+
 // DynamoDBStore is an implementation of Store using AWS DynamoDB to persist Challenges
 type DynamoDBStore struct {
 	c     dynamodbiface.DynamoDBAPI
 	table string
+	ttl   time.Duration
+	now   func() time.Time
 }
 
 // NewDynamoDBStore returns a pointer to a DynamoDBStore
@@ -50,17 +63,47 @@ func NewDynamoDBStore(c dynamodbiface.DynamoDBAPI, table string) *DynamoDBStore
 	return &DynamoDBStore{
 		c:     c,
 		table: table,
+		ttl:   DefaultDynamoDBTTL,
+		now:   time.Now,
 	}
 }
 
+// WithTTL allows you to override how long challenges written to DynamoDB
+// remain valid
+func (ds *DynamoDBStore) WithTTL(t time.Duration) *DynamoDBStore {
+	ds.ttl = t
+	return ds
+}
+
 const (
-	dynamoDBColumnDomain  = "domain"
-	dynamoDBColumnKeyAuth = "keyAuth"
-	dynamoDBColumnToken   = "token"
+	dynamoDBColumnDomain    = "domain"
+	dynamoDBColumnExpiresAt = "expiresAt"
+	dynamoDBColumnKeyAuth   = "keyAuth"
+	dynamoDBColumnToken     = "token"
 )
 
+// EnableDynamoDBTTL turns on DynamoDB's Time to Live feature for the expiresAt
+// attribute of the table, so that orphaned challenges are deleted automatically
+func EnableDynamoDBTTL(c dynamodbiface.DynamoDBAPI, table string) error {
+	in := &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(table),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(dynamoDBColumnExpiresAt),
+			Enabled:       aws.Bool(true),
+		},
+	}
+
+	_, err := c.UpdateTimeToLive(in)
+	return err
+}
+
 // DeleteChallenge deletes the relevant row from DynamoDB
 func (ds *DynamoDBStore) DeleteChallenge(token string) error {
+	return ds.DeleteChallengeWithContext(context.Background(), token)
+}
+
+// DeleteChallengeWithContext deletes the relevant row from DynamoDB
+func (ds *DynamoDBStore) DeleteChallengeWithContext(ctx context.Context, token string) error {
 	in := &dynamodb.DeleteItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			dynamoDBColumnToken: {
@@ -69,17 +112,24 @@ func (ds *DynamoDBStore) DeleteChallenge(token string) error {
 		},
 		TableName: aws.String(ds.table),
 	}
-	_, err := ds.c.DeleteItem(in)
+	_, err := ds.c.DeleteItemWithContext(ctx, in)
 
-	return parseDynamoDBError(err)
+	return parseDynamoDBError("DeleteChallenge", err)
 }
 
 // GetChallenge retrieves the relevant row from DynamoDB and returns it as a pointer
 // to a Challenge
 func (ds *DynamoDBStore) GetChallenge(token string) (*Challenge, error) {
+	return ds.GetChallengeWithContext(context.Background(), token)
+}
+
+// GetChallengeWithContext retrieves the relevant row from DynamoDB and returns it
+// as a pointer to a Challenge
+func (ds *DynamoDBStore) GetChallengeWithContext(ctx context.Context, token string) (*Challenge, error) {
 	in := &dynamodb.GetItemInput{
 		AttributesToGet: []*string{
 			aws.String(dynamoDBColumnDomain),
+			aws.String(dynamoDBColumnExpiresAt),
 			aws.String(dynamoDBColumnKeyAuth),
 		},
 		ConsistentRead: aws.Bool(true),
@@ -91,9 +141,20 @@ func (ds *DynamoDBStore) GetChallenge(token string) (*Challenge, error) {
 		TableName: aws.String(ds.table),
 	}
 
-	resp, err := ds.c.GetItem(in)
+	resp, err := ds.c.GetItemWithContext(ctx, in)
 	if err != nil {
-		return nil, parseDynamoDBError(err)
+		return nil, parseDynamoDBError("GetChallenge", err)
+	}
+
+	// DynamoDB doesn't return an error for a missing item, just an empty one
+	if len(resp.Item) == 0 {
+		return nil, newStoreError("GetChallenge", ErrStoreNotFound, nil)
+	}
+
+	// DynamoDB only deletes expired items periodically, so we need to make sure
+	// we don't serve a stale challenge in the meantime
+	if ds.expired(resp.Item[dynamoDBColumnExpiresAt]) {
+		return nil, newStoreError("GetChallenge", ErrStoreNotFound, nil)
 	}
 
 	return NewChallenge(aws.StringValue(resp.Item[dynamoDBColumnDomain].S), token, aws.StringValue(resp.Item[dynamoDBColumnKeyAuth].S)), nil
@@ -101,6 +162,11 @@ func (ds *DynamoDBStore) GetChallenge(token string) (*Challenge, error) {
 
 // PutChallenge serialises a Challenge and puts it in a row in DynamoDB
 func (ds *DynamoDBStore) PutChallenge(ch *Challenge) error {
+	return ds.PutChallengeWithContext(context.Background(), ch)
+}
+
+// PutChallengeWithContext serialises a Challenge and puts it in a row in DynamoDB
+func (ds *DynamoDBStore) PutChallengeWithContext(ctx context.Context, ch *Challenge) error {
 	in := &dynamodb.PutItemInput{
 		Item: map[string]*dynamodb.AttributeValue{
 			"domain": {
@@ -112,28 +178,51 @@ func (ds *DynamoDBStore) PutChallenge(ch *Challenge) error {
 			"keyAuth": {
 				S: aws.String(ch.KeyAuth),
 			},
+			"expiresAt": {
+				N: aws.String(strconv.FormatInt(ds.now().Add(ds.ttl).Unix(), 10)),
+			},
 		},
 		TableName: aws.String(ds.table),
 	}
 
-	_, err := ds.c.PutItem(in)
-	return parseDynamoDBError(err)
+	_, err := ds.c.PutItemWithContext(ctx, in)
+	return parseDynamoDBError("PutChallenge", err)
+}
+
+// expired checks the expiresAt attribute of a row. Rows written before we
+// started setting the attribute never expire.
+func (ds *DynamoDBStore) expired(av *dynamodb.AttributeValue) bool {
+	if av == nil || av.N == nil {
+		return false
+	}
+
+	expiresAt, err := strconv.ParseInt(aws.StringValue(av.N), 10, 64)
+	if err != nil {
+		log.Printf("[WARN] could not parse %v: %v", dynamoDBColumnExpiresAt, err)
+		return false
+	}
+
+	return !ds.now().Before(time.Unix(expiresAt, 0))
 }
 
 // parseDynamoDBError checks for known DynamoDB response codes to see if we can return a meaningful error
-func parseDynamoDBError(err error) error {
+func parseDynamoDBError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
 	log.Printf("[ERROR] %v", err)
 	if aerr, ok := err.(awserr.Error); ok {
 		switch aerr.Code() {
 		case dynamodb.ErrCodeProvisionedThroughputExceededException, dynamodb.ErrCodeRequestLimitExceeded:
 			// We exceeded our AWS limits
-			return ErrStoreRateLimited
+			return newStoreError(op, ErrStoreRateLimited, err)
 		case dynamodb.ErrCodeResourceNotFoundException:
-			// Challenge didn't exist
-			return ErrStoreNotFound
+			// The table didn't exist
+			return newStoreError(op, ErrStoreTableNotFound, err)
 		}
 	}
 
 	// Some other unexpected error condition
-	return err
+	return newStoreError(op, nil, err)
 }
@@ -1,9 +1,12 @@
 package http
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	petname "github.com/dustinkirkland/golang-petname"
@@ -21,6 +24,24 @@ func init() {
 	dyn, mock = dynamock.New()
 }
 
+// testNow is a fixed point in time so that we can predict the expiresAt column
+var testNow = time.Unix(1600000000, 0)
+
+func testClock() time.Time {
+	return testNow
+}
+
+// fakeTTLClient records the input to UpdateTimeToLive
+type fakeTTLClient struct {
+	dynamodbiface.DynamoDBAPI
+	in *dynamodb.UpdateTimeToLiveInput
+}
+
+func (f *fakeTTLClient) UpdateTimeToLive(in *dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	f.in = in
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
 func TestDynamoDBStoreDeleteChallenge(t *testing.T) {
 	table := petname.Generate(2, "-")
 	store := NewDynamoDBStore(dyn, table)
@@ -74,13 +95,52 @@ func TestDynamoDBStoreGetChallenge(t *testing.T) {
 	helpers.ExpectStringMatch(t, "c", ch.KeyAuth)
 }
 
-func TestDynamoDBStorePutChallenge(t *testing.T) {
+func TestDynamoDBStoreGetChallenge_expired(t *testing.T) {
 	table := petname.Generate(2, "-")
 	store := NewDynamoDBStore(dyn, table)
+	store.now = testClock
+
+	expectedKey := map[string]*dynamodb.AttributeValue{
+		"token": {
+			S: aws.String("b"),
+		},
+	}
+
+	output := dynamodb.GetItemOutput{
+		Item: map[string]*dynamodb.AttributeValue{
+			"domain": {
+				S: aws.String("a"),
+			},
+			"expiresAt": {
+				N: aws.String("1599999999"),
+			},
+			"keyAuth": {
+				S: aws.String("c"),
+			},
+			"token": {
+				S: aws.String("b"),
+			},
+		},
+	}
+
+	mock.ExpectGetItem().ToTable(table).WithKeys(expectedKey).WillReturns(output)
+	_, err := store.GetChallenge("b")
+	if !errors.Is(err, ErrStoreNotFound) {
+		t.Errorf("Expected %v, got %v", ErrStoreNotFound, err)
+	}
+}
+
+func TestDynamoDBStorePutChallenge(t *testing.T) {
+	table := petname.Generate(2, "-")
+	store := NewDynamoDBStore(dyn, table).WithTTL(time.Minute)
+	store.now = testClock
 	mock.ExpectPutItem().ToTable(table).WithItems(map[string]*dynamodb.AttributeValue{
 		"domain": {
 			S: aws.String("a"),
 		},
+		"expiresAt": {
+			N: aws.String("1600000060"),
+		},
 		"keyAuth": {
 			S: aws.String("c"),
 		},
@@ -94,3 +154,61 @@ func TestDynamoDBStorePutChallenge(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestEnableDynamoDBTTL(t *testing.T) {
+	c := &fakeTTLClient{}
+
+	err := EnableDynamoDBTTL(c, "challenges")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, "challenges", aws.StringValue(c.in.TableName))
+	helpers.ExpectStringMatch(t, "expiresAt", aws.StringValue(c.in.TimeToLiveSpecification.AttributeName))
+	if !aws.BoolValue(c.in.TimeToLiveSpecification.Enabled) {
+		t.Error("Expected TTL to be enabled")
+	}
+}
+
+func TestDynamoDBStoreGetChallenge_notFound(t *testing.T) {
+	table := petname.Generate(2, "-")
+	store := NewDynamoDBStore(dyn, table)
+
+	expectedKey := map[string]*dynamodb.AttributeValue{
+		"token": {
+			S: aws.String("b"),
+		},
+	}
+
+	mock.ExpectGetItem().ToTable(table).WithKeys(expectedKey).WillReturns(dynamodb.GetItemOutput{})
+	_, err := store.GetChallenge("b")
+	if !errors.Is(err, ErrStoreNotFound) {
+		t.Errorf("Expected %v, got %v", ErrStoreNotFound, err)
+	}
+}
+
+func TestParseDynamoDBError(t *testing.T) {
+	tests := []struct {
+		code string
+		kind error
+	}{
+		{dynamodb.ErrCodeProvisionedThroughputExceededException, ErrStoreRateLimited},
+		{dynamodb.ErrCodeRequestLimitExceeded, ErrStoreRateLimited},
+		{dynamodb.ErrCodeResourceNotFoundException, ErrStoreTableNotFound},
+	}
+
+	for _, tt := range tests {
+		aerr := awserr.New(tt.code, "message", nil)
+		err := parseDynamoDBError("GetChallenge", aerr)
+		if !errors.Is(err, tt.kind) {
+			t.Errorf("%v: expected %v, got %v", tt.code, tt.kind, err)
+		}
+		if !errors.Is(err, aerr) {
+			t.Errorf("%v: expected the AWS error to be wrapped", tt.code)
+		}
+	}
+
+	if err := parseDynamoDBError("GetChallenge", nil); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+}
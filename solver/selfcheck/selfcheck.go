@@ -0,0 +1,209 @@
+// package selfcheck verifies that a challenge can be seen from the outside before
+// the CA is asked to validate it. A failed validation counts against the CA's
+// rate limits, so it is much cheaper to find out that DNS, replication or
+// propagation isn't ready ourselves.
+package selfcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+)
+
+const (
+	// DefaultInterval is how long we wait between attempts
+	DefaultInterval = 2 * time.Second
+	// DefaultTimeout is how long we keep trying before giving up
+	DefaultTimeout = 2 * time.Minute
+)
+
+// maxBodySize is more than enough for a keyauth
+const maxBodySize = 4096
+
+// idPeAcmeIdentifierV1 is the OID of the challenge certificate extension that
+// holds the SHA-256 digest of the keyauth (RFC 8737)
+var idPeAcmeIdentifierV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// Checker polls a challenge the same way the CA will until the expected keyauth
+// is served, or the timeout elapses
+type Checker struct {
+	client   *http.Client
+	dialer   *net.Dialer
+	interval time.Duration
+	timeout  time.Duration
+
+	// The CA always uses the standard ports; they can be changed for testing
+	httpPort string
+	tlsPort  string
+}
+
+// New returns a pointer to a Checker with the default interval and timeout
+func New() *Checker {
+	return &Checker{
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		dialer: &net.Dialer{
+			Timeout: 10 * time.Second,
+		},
+		interval: DefaultInterval,
+		timeout:  DefaultTimeout,
+		httpPort: "80",
+		tlsPort:  "443",
+	}
+}
+
+// WithInterval allows you to override DefaultInterval
+func (c *Checker) WithInterval(t time.Duration) *Checker {
+	c.interval = t
+	return c
+}
+
+// WithTimeout allows you to override DefaultTimeout
+func (c *Checker) WithTimeout(t time.Duration) *Checker {
+	c.timeout = t
+	return c
+}
+
+// WithHTTPClient allows you to supply your own http.Client for HTTP-01 checks
+func (c *Checker) WithHTTPClient(client *http.Client) *Checker {
+	c.client = client
+	return c
+}
+
+// HTTP01 fetches http://<domain>/.well-known/acme-challenge/<token> until the
+// keyauth is served
+func (c *Checker) HTTP01(ctx context.Context, domain, token, keyAuth string) error {
+	host := domain
+	if c.httpPort != "80" {
+		host = net.JoinHostPort(domain, c.httpPort)
+	}
+	u := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", host, token)
+
+	return c.poll(ctx, domain, func(ctx context.Context) error {
+		return c.fetch(ctx, u, keyAuth)
+	})
+}
+
+// TLSALPN01 performs TLS handshakes with <domain>:443 until the challenge
+// certificate for the keyauth is served, whether or not acme-tls/1 is
+// negotiated
+func (c *Checker) TLSALPN01(ctx context.Context, domain, keyAuth string) error {
+	return c.poll(ctx, domain, func(ctx context.Context) error {
+		return c.handshake(ctx, domain, keyAuth)
+	})
+}
+
+// poll calls check every interval until it succeeds, returning the last error
+// if it hasn't by the timeout
+func (c *Checker) poll(ctx context.Context, domain string, check func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		err := check(ctx)
+		if err == nil {
+			log.Printf("[INFO] self check for %v passed after %d attempt(s)", domain, attempt)
+			return nil
+		}
+
+		log.Printf("[DEBUG] self check for %v failed (attempt %d): %v", domain, attempt, err)
+
+		t := time.NewTimer(c.interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("self check for %v did not pass after %d attempt(s): %w", domain, attempt, err)
+		case <-t.C:
+		}
+	}
+}
+
+// fetch checks that the URL serves the keyauth
+func (c *Checker) fetch(ctx context.Context, u, keyAuth string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return err
+	}
+
+	// The CA ignores trailing whitespace
+	if got := strings.TrimRight(string(body), " \t\r\n"); got != keyAuth {
+		return fmt.Errorf("expected keyauth %q, got %q", keyAuth, got)
+	}
+
+	return nil
+}
+
+// handshake checks that a handshake offering acme-tls/1 returns the challenge
+// certificate for the keyauth
+func (c *Checker) handshake(ctx context.Context, domain, keyAuth string) error {
+	d := &tls.Dialer{
+		NetDialer: c.dialer,
+		Config: &tls.Config{
+			ServerName: domain,
+			NextProtos: []string{tlsalpn01.ACMETLS1Protocol},
+			// The challenge certificate is self-signed; we check its contents below
+			InsecureSkipVerify: true,
+		},
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(domain, c.tlsPort))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The protocol isn't checked: endpoints like API Gateway custom domains
+	// serve the certificate without negotiating acme-tls/1
+	state := conn.(*tls.Conn).ConnectionState()
+	cert := state.PeerCertificates[0]
+	if len(cert.DNSNames) != 1 || !strings.EqualFold(cert.DNSNames[0], domain) {
+		return fmt.Errorf("expected a certificate for %v, got %v", domain, cert.DNSNames)
+	}
+
+	expected := sha256.Sum256([]byte(keyAuth))
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(idPeAcmeIdentifierV1) {
+			continue
+		}
+
+		var digest []byte
+		if _, err := asn1.Unmarshal(ext.Value, &digest); err != nil {
+			return fmt.Errorf("parsing acmeIdentifier: %w", err)
+		}
+		if !bytes.Equal(digest, expected[:]) {
+			return errors.New("the certificate is for a different keyauth")
+		}
+
+		return nil
+	}
+
+	return errors.New("the certificate is not a challenge certificate")
+}
@@ -0,0 +1,135 @@
+package selfcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+)
+
+// testChecker returns a Checker that polls quickly
+func testChecker() *Checker {
+	return New().WithInterval(time.Millisecond).WithTimeout(time.Second)
+}
+
+func TestHTTP01(t *testing.T) {
+	// The challenge becomes visible on the third request
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/.well-known/acme-challenge/token" {
+			http.NotFound(w, req)
+			return
+		}
+		if atomic.AddInt32(&requests, 1) < 3 {
+			http.NotFound(w, req)
+			return
+		}
+		io.WriteString(w, "token.keyauth\n")
+	}))
+	defer srv.Close()
+
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	c := testChecker()
+	c.httpPort = port
+
+	err := c.HTTP01(context.Background(), host, "token", "token.keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("Expected 3 requests, got %v", n)
+	}
+}
+
+func TestHTTP01_timeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "something.else")
+	}))
+	defer srv.Close()
+
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	c := testChecker().WithTimeout(50 * time.Millisecond)
+	c.httpPort = port
+
+	err := c.HTTP01(context.Background(), host, "token", "token.keyauth")
+	if err == nil {
+		t.Errorf("Expected the self check to time out")
+	}
+}
+
+// testTLSServer serves acme-tls/1 handshakes with a challenge certificate for
+// the keyauth
+func testTLSServer(t *testing.T, domain, keyAuth string) string {
+	return testTLSServerProtos(t, domain, keyAuth, tlsalpn01.ACMETLS1Protocol)
+}
+
+// testTLSServerProtos serves handshakes for the protocols with a challenge
+// certificate for the keyauth
+func testTLSServerProtos(t *testing.T, domain, keyAuth string, protos ...string) string {
+	cert, err := tlsalpn01.ChallengeCert(domain, keyAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		NextProtos:   protos,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+func TestTLSALPN01(t *testing.T) {
+	c := testChecker()
+	c.tlsPort = testTLSServer(t, "localhost", "token.keyauth")
+
+	err := c.TLSALPN01(context.Background(), "localhost", "token.keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSALPN01_wrongKeyAuth(t *testing.T) {
+	c := testChecker().WithTimeout(50 * time.Millisecond)
+	c.tlsPort = testTLSServer(t, "localhost", "token.keyauth")
+
+	err := c.TLSALPN01(context.Background(), "localhost", "token.other")
+	if err == nil {
+		t.Errorf("Expected the self check to fail for a different keyauth")
+	}
+}
+
+func TestTLSALPN01_withoutALPN(t *testing.T) {
+	// e.g. an API Gateway custom domain, which doesn't negotiate acme-tls/1
+	c := testChecker()
+	c.tlsPort = testTLSServerProtos(t, "localhost", "token.keyauth")
+
+	err := c.TLSALPN01(context.Background(), "localhost", "token.keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
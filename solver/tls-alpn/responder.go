@@ -0,0 +1,252 @@
+package tlsalpn
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+	solver "github.com/sjauld/acme-sls/solver/http"
+)
+
+// DefaultHandshakeTimeout is how long a client has to complete the TLS
+// handshake, or for the ClientHello to arrive if the connection is passed
+// through to the backend
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// minAcceptDelay and maxAcceptDelay bound the backoff after a temporary Accept
+// error
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// Responder answers acme-tls/1 handshakes from the remote CA with a challenge
+// certificate built from the Store. Every other TLS connection is passed
+// through, unmodified, to a backend so that the Responder can sit in front of
+// an existing TLS server on port 443.
+type Responder struct {
+	store   solver.Store
+	backend string
+	timeout time.Duration
+	dial    func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// NewResponder returns a pointer to a Responder, initialised with a Store of
+// your choice. Without a backend, connections that are not for acme-tls/1 are
+// closed.
+func NewResponder(store solver.Store) *Responder {
+	return &Responder{
+		store:   store,
+		timeout: DefaultHandshakeTimeout,
+		dial:    (&net.Dialer{Timeout: DefaultHandshakeTimeout}).DialContext,
+	}
+}
+
+// WithBackend sets the address (host:port) that normal TLS traffic is passed
+// through to
+func (r *Responder) WithBackend(addr string) *Responder {
+	r.backend = addr
+	return r
+}
+
+// WithHandshakeTimeout allows you to override DefaultHandshakeTimeout
+func (r *Responder) WithHandshakeTimeout(t time.Duration) *Responder {
+	r.timeout = t
+	return r
+}
+
+// Serve accepts connections on the listener and handles each one in a new
+// goroutine. Temporary errors, e.g. running out of file descriptors, are
+// retried with a backoff the way net/http does. Otherwise it returns when the
+// listener fails, e.g. because it was closed.
+func (r *Responder) Serve(ln net.Listener) error {
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = minAcceptDelay
+				} else if delay *= 2; delay > maxAcceptDelay {
+					delay = maxAcceptDelay
+				}
+				log.Printf("[ERROR] accept error: %v; retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+
+			return err
+		}
+		delay = 0
+
+		go r.ServeConn(conn)
+	}
+}
+
+// ServeConn handles a single connection, closing it when done
+func (r *Responder) ServeConn(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(r.timeout))
+
+	hello, pc, err := peekClientHello(conn)
+	if err != nil {
+		log.Printf("[ERROR] could not read ClientHello from %v: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	if isACMETLS(hello) {
+		log.Printf("[DEBUG] acme-tls/1 handshake from %v for %v", conn.RemoteAddr(), hello.ServerName)
+		r.serveChallenge(pc)
+		return
+	}
+
+	r.passThrough(pc)
+}
+
+// serveChallenge completes the handshake with the challenge certificate. The
+// CA closes the connection once it has seen the certificate, so there is
+// nothing else to do.
+func (r *Responder) serveChallenge(conn net.Conn) {
+	tlsConn := tls.Server(conn, &tls.Config{
+		NextProtos:     []string{tlsalpn01.ACMETLS1Protocol},
+		GetCertificate: r.getCertificate,
+	})
+
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("[ERROR] acme-tls/1 handshake failed: %v", err)
+	}
+}
+
+// getCertificate builds the challenge certificate for the SNI of the handshake
+func (r *Responder) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName == "" {
+		return nil, errors.New("acme-tls/1 requires SNI")
+	}
+
+	key, err := ChallengeKey(hello.ServerName)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	ch, err := r.store.GetChallengeWithContext(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("could not GetChallenge for %v: %w", hello.ServerName, err)
+	}
+
+	return tlsalpn01.ChallengeCert(ch.Domain, ch.KeyAuth)
+}
+
+// passThrough proxies the connection, including the ClientHello, to the backend
+func (r *Responder) passThrough(conn net.Conn) {
+	if r.backend == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	upstream, err := r.dial(ctx, "tcp", r.backend)
+	if err != nil {
+		log.Printf("[ERROR] could not connect to backend %v: %v", r.backend, err)
+		return
+	}
+	defer upstream.Close()
+
+	// The deadline only applies to the handshake; the backend decides how long
+	// connections may live
+	conn.SetDeadline(time.Time{})
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, conn)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		closeWrite(conn)
+		done <- struct{}{}
+	}()
+
+	<-done
+	<-done
+}
+
+// closeWrite signals EOF to the other side of a connection, if it supports it
+func closeWrite(conn net.Conn) {
+	if pc, ok := conn.(*peekedConn); ok {
+		conn = pc.Conn
+	}
+
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		conn.Close()
+	}
+}
+
+// isACMETLS reports whether the client only offered the acme-tls/1 protocol.
+// RFC 8737 says the CA must not offer any other protocol.
+func isACMETLS(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == tlsalpn01.ACMETLS1Protocol
+}
+
+// errHelloRead stops the handshake once we have the ClientHello
+var errHelloRead = errors.New("ClientHello read")
+
+// peekClientHello reads the ClientHello from the connection, returning it along
+// with a connection that will replay the bytes that were read
+func peekClientHello(conn net.Conn) (*tls.ClientHelloInfo, net.Conn, error) {
+	var buf bytes.Buffer
+	var hello *tls.ClientHelloInfo
+
+	err := tls.Server(readOnlyConn{r: io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = &tls.ClientHelloInfo{
+				ServerName:      h.ServerName,
+				SupportedProtos: h.SupportedProtos,
+			}
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if hello == nil {
+		return nil, nil, err
+	}
+
+	return hello, &peekedConn{Conn: conn, r: io.MultiReader(&buf, conn)}, nil
+}
+
+// peekedConn is a net.Conn that reads from r, which replays what was peeked
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (pc *peekedConn) Read(b []byte) (int, error) {
+	return pc.r.Read(b)
+}
+
+// readOnlyConn lets crypto/tls parse a ClientHello without writing a response
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(b []byte) (int, error)         { return c.r.Read(b) }
+func (c readOnlyConn) Write(b []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
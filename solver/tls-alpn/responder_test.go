@@ -0,0 +1,182 @@
+package tlsalpn
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+
+	"github.com/sjauld/acme-sls/helpers"
+)
+
+// idPeAcmeIdentifierV1 is the OID of the extension holding the keyauth digest
+var idPeAcmeIdentifierV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// testResponder serves a Responder on a local listener, returning its address
+func testResponder(t *testing.T, r *Responder) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go r.Serve(ln)
+
+	return ln.Addr().String()
+}
+
+func TestResponder_challenge(t *testing.T) {
+	store := testStore(t)
+	err := New(store).Present("www.example.com", "token", "keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := testResponder(t, NewResponder(store))
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         "www.example.com",
+		NextProtos:         []string{tlsalpn01.ACMETLS1Protocol},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	helpers.ExpectStringMatch(t, tlsalpn01.ACMETLS1Protocol, state.NegotiatedProtocol)
+
+	cert := state.PeerCertificates[0]
+	helpers.ExpectIntMatch(t, 1, len(cert.DNSNames))
+	helpers.ExpectStringMatch(t, "www.example.com", cert.DNSNames[0])
+
+	// The certificate must carry the SHA-256 digest of the keyauth
+	expected := sha256.Sum256([]byte("keyauth"))
+	found := false
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(idPeAcmeIdentifierV1) {
+			continue
+		}
+		var digest []byte
+		if _, err := asn1.Unmarshal(ext.Value, &digest); err != nil {
+			t.Fatal(err)
+		}
+		found = string(digest) == string(expected[:])
+	}
+	if !found {
+		t.Errorf("Challenge certificate does not contain the keyauth digest")
+	}
+}
+
+// temporaryError is a net.Error like the one Accept returns when the process
+// runs out of file descriptors
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails the first few Accepts with a temporary error
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, temporaryError{}
+	}
+
+	return l.Listener.Accept()
+}
+
+func TestResponder_temporaryAcceptError(t *testing.T) {
+	store := testStore(t)
+	if err := New(store).Present("www.example.com", "token", "keyauth"); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- NewResponder(store).Serve(&flakyListener{Listener: ln, failures: 3}) }()
+
+	// Serve keeps going after the temporary errors
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		ServerName:         "www.example.com",
+		NextProtos:         []string{tlsalpn01.ACMETLS1Protocol},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// and returns once the listener is closed
+	ln.Close()
+	if err := <-done; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestResponder_unknownDomain(t *testing.T) {
+	addr := testResponder(t, NewResponder(testStore(t)))
+
+	_, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         "www.unknown.com",
+		NextProtos:         []string{tlsalpn01.ACMETLS1Protocol},
+		InsecureSkipVerify: true,
+	})
+	if err == nil {
+		t.Errorf("Expected the handshake to fail for a domain without a challenge")
+	}
+}
+
+func TestResponder_passThrough(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "backend")
+	}))
+	defer backend.Close()
+
+	addr := testResponder(t, NewResponder(testStore(t)).WithBackend(backend.Listener.Addr().String()))
+
+	client := backend.Client()
+	resp, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectIntMatch(t, http.StatusOK, resp.StatusCode)
+	helpers.ExpectStringMatch(t, "backend", string(body))
+}
+
+func TestResponder_noBackend(t *testing.T) {
+	addr := testResponder(t, NewResponder(testStore(t)))
+
+	_, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         "www.example.com",
+		InsecureSkipVerify: true,
+	})
+	if err == nil {
+		t.Errorf("Expected the connection to be closed without a backend")
+	}
+}
@@ -0,0 +1,92 @@
+// package tlsalpn solves the ACMEv2 TLS-ALPN-01 challenge without AWS specific
+// infrastructure. The workflow is as follows:
+//
+// 1. client requests a certificate from the remote CA, using the Solver as the TLS-ALPN-01 challenge
+// 2. Solver populates the Challenge in the Store, keyed by domain, and notifies the CA that the challenge is ready
+// 3. remote CA opens a TLS connection to the server offering the acme-tls/1 protocol
+// 4. server retrieves the Challenge from the Store and completes the handshake with a challenge certificate
+//
+// Any Store from the http solver package can be shared between the client and
+// the server. The server side is provided by the Responder, which passes normal
+// TLS traffic through to a backend.
+package tlsalpn
+
+import (
+	"context"
+	"log"
+
+	solver "github.com/sjauld/acme-sls/solver/http"
+)
+
+// KeyPrefix is prepended to the domain to form the key of a TLS-ALPN-01
+// challenge in the Store. The prefix contains characters that are not valid in
+// an HTTP-01 token, so the two challenge types can share a Store.
+const KeyPrefix = "tls-alpn-01:"
+
+// ChallengeKey returns the key of the TLS-ALPN-01 challenge for a domain. The CA
+// validates each domain separately, so there is only ever one at a time.
+func ChallengeKey(domain string) (string, error) {
+	domain, err := solver.NormaliseHost(domain)
+	if err != nil {
+		return "", err
+	}
+
+	return KeyPrefix + domain, nil
+}
+
+// Solver implements lego's challenge.Provider
+type Solver struct {
+	ctx   context.Context
+	store solver.Store
+}
+
+// New returns a pointer to a Solver, initialised with a Store of your choice
+func New(store solver.Store) *Solver {
+	return &Solver{
+		ctx:   context.Background(),
+		store: store,
+	}
+}
+
+// WithContext sets the context used by Present and CleanUp
+func (s *Solver) WithContext(ctx context.Context) *Solver {
+	s.ctx = ctx
+	return s
+}
+
+// Present writes the challenge information into the Store so that the
+// Responder can build a challenge certificate for the domain
+func (s *Solver) Present(domain, token, keyAuth string) error {
+	return s.PresentWithContext(s.ctx, domain, token, keyAuth)
+}
+
+// PresentWithContext is the same as Present with the addition of the ability
+// to pass a context
+func (s *Solver) PresentWithContext(ctx context.Context, domain, token, keyAuth string) error {
+	log.Printf("[INFO] Presenting domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
+
+	key, err := ChallengeKey(domain)
+	if err != nil {
+		return err
+	}
+
+	return s.store.PutChallengeWithContext(ctx, solver.NewChallenge(domain, key, keyAuth))
+}
+
+// CleanUp removes the challenge information from the Store
+func (s *Solver) CleanUp(domain, token, keyAuth string) error {
+	return s.CleanUpWithContext(s.ctx, domain, token, keyAuth)
+}
+
+// CleanUpWithContext is the same as CleanUp with the addition of the ability
+// to pass a context
+func (s *Solver) CleanUpWithContext(ctx context.Context, domain, token, keyAuth string) error {
+	log.Printf("[INFO] CleaningUp domain: %v, token: %v, keyauth: %v", domain, token, keyAuth)
+
+	key, err := ChallengeKey(domain)
+	if err != nil {
+		return err
+	}
+
+	return s.store.DeleteChallengeWithContext(ctx, key)
+}
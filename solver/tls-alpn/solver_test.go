@@ -0,0 +1,62 @@
+package tlsalpn
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sjauld/acme-sls/helpers"
+	solver "github.com/sjauld/acme-sls/solver/http"
+)
+
+func testStore(t *testing.T) solver.Store {
+	mr := miniredis.RunT(t)
+	c := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	t.Cleanup(func() { c.Close() })
+
+	return solver.NewRedisStore(c)
+}
+
+func TestChallengeKey(t *testing.T) {
+	key, err := ChallengeKey("WWW.Example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helpers.ExpectStringMatch(t, "tls-alpn-01:www.example.com", key)
+
+	if solver.ValidToken(key) {
+		t.Errorf("%v should not be a valid HTTP-01 token", key)
+	}
+}
+
+func TestPresentCleanUp(t *testing.T) {
+	store := testStore(t)
+	s := New(store)
+
+	err := s.Present("www.Example.com", "token", "keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := store.GetChallenge("tls-alpn-01:www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	helpers.ExpectStringMatch(t, "www.example.com", ch.Domain)
+	helpers.ExpectStringMatch(t, "keyauth", ch.KeyAuth)
+
+	err = s.CleanUp("www.example.com", "token", "keyauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.GetChallenge("tls-alpn-01:www.example.com")
+	if !errors.Is(err, solver.ErrStoreNotFound) {
+		t.Errorf("Expected %v, got %v", solver.ErrStoreNotFound, err)
+	}
+}
@@ -7,9 +7,14 @@
 data "aws_region" "current" {}
 
 locals {
-  domains = distinct(flatten([for k, v in var.certificates : v]))
+  # Wildcards can't have a bucket, and are validated with DNS-01 instead
+  domains = distinct([for d in flatten([for k, v in var.certificates : v]) : d if substr(d, 0, 1) != "*"])
+  # Route 53 is only needed if a certificate has a DNS-01 rule
+  dns01 = length([for r in flatten([for k, v in var.challenges : v]) : r if r.type == "dns-01"]) > 0
   # List of buckets to replicate, bearing in mind that we shouldn't self-replicate
   bucket_replications = var.replication_target_bucket_arn == "" ? [] : tolist(setsubtract(local.domains, [split(":", var.replication_target_bucket_arn)[5]]))
+  # The lambda waits for challenges to reach the replication target (bucket[@region])
+  replica_buckets = var.replication_target_bucket_arn == "" ? "" : join("@", compact([split(":", var.replication_target_bucket_arn)[5], var.replication_target_region]))
 }
 
 # This function solves the HTTP-01 challenge
@@ -31,10 +36,12 @@ resource "aws_lambda_function" "challenge" {
 
   environment {
     variables = {
-      "RENEWAL_WINDOW" = "${var.renewal_window_hours}h"
-      "S3_DELAY"       = "${var.s3_delay_seconds}s"
-      "S3_REGION"      = coalesce(var.aws_s3_region, data.aws_region.current.name)
-      "USER_EMAIL"     = var.user_email
+      "RENEWAL_WINDOW"     = "${var.renewal_window_hours}h"
+      "S3_DELAY"           = "${var.s3_delay_seconds}s"
+      "S3_REGION"          = coalesce(var.aws_s3_region, data.aws_region.current.name)
+      "S3_REPLICA_BUCKETS" = local.replica_buckets
+      "SELF_CHECK_TIMEOUT" = "${var.self_check_timeout_seconds}s"
+      "USER_EMAIL"         = var.user_email
     }
   }
 
@@ -101,6 +108,55 @@ data "aws_iam_policy_document" "lambda" {
     resources = formatlist("arn:aws:s3:::%v/.well-known/acme-challenge/*", local.domains)
   }
 
+  # HeadObject on the source and replication target, to wait for replication
+  dynamic "statement" {
+    for_each = var.replication_target_bucket_arn == "" ? [] : [1]
+
+    content {
+      sid = "S3Replication"
+
+      actions = [
+        "s3:GetObject",
+      ]
+
+      resources = formatlist("arn:aws:s3:::%v/.well-known/acme-challenge/*", concat(local.domains, [split(":", var.replication_target_bucket_arn)[5]]))
+    }
+  }
+
+  # Without ListBucket, HeadObject on a challenge that hasn't replicated yet is
+  # a 403 rather than a 404
+  dynamic "statement" {
+    for_each = var.replication_target_bucket_arn == "" ? [] : [1]
+
+    content {
+      sid = "S3ReplicationList"
+
+      actions = [
+        "s3:ListBucket",
+      ]
+
+      resources = formatlist("arn:aws:s3:::%v", concat(local.domains, [split(":", var.replication_target_bucket_arn)[5]]))
+    }
+  }
+
+  # Creating and checking the TXT records for DNS-01 challenges
+  dynamic "statement" {
+    for_each = local.dns01 ? [1] : []
+
+    content {
+      sid = "Route53"
+
+      actions = [
+        "route53:ChangeResourceRecordSets",
+        "route53:GetChange",
+        "route53:ListHostedZonesByName",
+        "route53:ListResourceRecordSets",
+      ]
+
+      resources = ["*"]
+    }
+  }
+
   statement {
     sid = "ACM"
 
@@ -140,7 +196,7 @@ resource "aws_cloudwatch_event_target" "challenge" {
 
   arn   = aws_lambda_function.challenge.arn
   rule  = aws_cloudwatch_event_rule.challenge.id
-  input = jsonencode({ "detail" = { "id" = each.key, "domains" = each.value } })
+  input = jsonencode({ "detail" = { "id" = each.key, "domains" = each.value, "challenges" = lookup(var.challenges, each.key, []) } })
 }
 
 resource "aws_lambda_permission" "challenge" {
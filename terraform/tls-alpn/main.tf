@@ -82,7 +82,9 @@ data "aws_iam_policy_document" "lambda" {
     sid = "ACM"
 
     actions = [
+      "acm:DeleteCertificate",
       "acm:DescribeCertificate",
+      "acm:GetCertificate",
       "acm:ImportCertificate",
       "acm:ListCertificates",
       "acm:ListTagsForCertificate",
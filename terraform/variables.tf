@@ -14,6 +14,16 @@ variable "certificates" {
   type        = map(list(string))
 }
 
+variable "challenges" {
+  description = "Per certificate, the challenge type and provider for each domain pattern, e.g. { type = \"dns-01\", provider = \"route53\" } for wildcards - domains without a rule use HTTP-01 via S3"
+  default     = {}
+  type = map(list(object({
+    domain   = string
+    type     = string
+    provider = string
+  })))
+}
+
 variable "create_buckets" {
   description = "Set this to false to BYO buckets"
   default     = true
@@ -55,6 +65,12 @@ variable "replication_target_bucket_arn" {
   type        = string
 }
 
+variable "replication_target_region" {
+  description = "The region of the replication target bucket, if it is different from the challenge buckets"
+  default     = ""
+  type        = string
+}
+
 variable "replication_role_arn" {
   description = "An appropriate role if you need to replicate challenges"
   default     = ""
@@ -62,11 +78,17 @@ variable "replication_role_arn" {
 }
 
 variable "s3_delay_seconds" {
-  description = "Add a delay here if you are relying on S3 replication"
+  description = "Add a delay after writing each challenge - no longer needed for S3 replication, since the lambda waits for the object to reach the replication target"
   default     = 0
   type        = number
 }
 
+variable "self_check_timeout_seconds" {
+  description = "How long to wait for a challenge to be reachable before asking Let's Encrypt to validate it - set to 0 to skip the check"
+  default     = 120
+  type        = number
+}
+
 variable "timeout" {
   description = "The lambda timeout"
   default     = 300